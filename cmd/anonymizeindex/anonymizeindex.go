@@ -1,15 +1,16 @@
 package anonymizeindex
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"net/http/httputil"
 	"strings"
 	"time"
 
 	"github.com/danielfireman/esperf/anon"
+	"github.com/danielfireman/esperf/internal/esclient"
 	"github.com/spf13/cobra"
 )
 
@@ -20,12 +21,15 @@ var (
 	from, size, total int
 	ctxDuration       string
 	cont              bool
+	timeout           time.Duration
+	client            *esclient.Client
 )
 
 func init() {
 	RootCmd.Flags().StringSliceVar(&anonFields, "anon_fields", []string{}, "Name of the fields in the source document that must be anonymized. Only accept numbers and strings.")
 	RootCmd.Flags().StringVar(&anonymizedMap, "anonymized_map_path", "", "Path to the dictionary of anonymized fields.")
 	RootCmd.Flags().BoolVar(&debug, "debug", false, "Dump requests and responses.")
+	RootCmd.Flags().DurationVar(&timeout, "timeout", 30*time.Second, "Timeout to be used in connections to ES.")
 	RootCmd.Flags().BoolVar(&cont, "continue", false, "Reads the last scroll id from file and continues from there.")
 	RootCmd.Flags().IntVar(&from, "from", 0, "The from parameter defines the per-page offset from the first result you want to fetch.")
 	RootCmd.Flags().IntVar(&size, "size", 10, "The size parameter allows you to configure the maximum amount of hits to be returned per page.")
@@ -63,6 +67,16 @@ var RootCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		client, err = esclient.New(esclient.Default, esclient.Options{
+			Timeout: timeout,
+			Debug:   debug,
+			// Giving elasticsearch a breath.
+			Retries:   maxRetries - 1,
+			RetryWait: time.Second,
+		})
+		if err != nil {
+			return err
+		}
 
 		// Reading file content and creating a new fake search response which contains only the scroll id.
 		if cont {
@@ -157,40 +171,20 @@ func newAnonymizer(cont bool, anonMapPath string, anonFields []string) (*anon.An
 const maxRetries = 5
 
 func makeRequest(u, body string) (*searchResponse, error) {
-	for i := 1; ; i++ {
-		req, err := http.NewRequest("GET", u, strings.NewReader(body))
-		if err != nil {
-			return nil, err
-		}
-		if debug {
-			dReq, _ := httputil.DumpRequest(req, true)
-			fmt.Println(string(dReq))
-		}
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			if i == maxRetries {
-				return nil, err
-			}
-			if debug {
-				fmt.Printf("Error:[%q] Retrying:[count:%d, max:%d]", err, i, maxRetries)
-			}
-			// Giving elasticsearch a breath.
-			time.Sleep(time.Second)
-			continue
-		}
-		if debug {
-			dResp, _ := httputil.DumpResponse(resp, true)
-			fmt.Println(string(dResp))
-		}
-		bodyBuf, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return nil, err
-		}
-		resp.Body.Close()
-		var sr searchResponse
-		if err := json.Unmarshal(bodyBuf, &sr); err != nil {
-			return nil, fmt.Errorf("error parsing response %q", err)
-		}
-		return &sr, nil
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := esclient.NewRequest(ctx, "GET", u, body, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var sr searchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&sr); err != nil {
+		return nil, fmt.Errorf("error parsing response %q", err)
 	}
+	return &sr, nil
 }
@@ -9,7 +9,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net"
 	"net/http"
 	"net/http/httputil"
 	"os"
@@ -18,6 +17,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/danielfireman/esperf/internal/esclient"
 	"github.com/spf13/cobra"
 )
 
@@ -30,8 +30,6 @@ var (
 	debug      bool
 	dict       string
 	timeout    time.Duration
-	// DefaultLocalAddr is the default local IP address an Attacker uses.
-	defaultLocalAddr = net.IPAddr{IP: net.IPv4zero}
 	// DefaultConnections is the default amount of max open idle connections per
 	// target host.
 	defaultConnections = 10000
@@ -79,20 +77,17 @@ var RootCmd = &cobra.Command{
 			return fmt.Errorf("query defintion uses $RDICT, please set --dictionary_file.")
 		}
 
-		// TODO(danielfireman): Refactor client creation code between here and replay packages.
-		clients := make(chan *http.Client, numClients)
+		clients := make(chan *esclient.Client, numClients)
 		for i := 0; i < numClients; i++ {
-			clients <- &http.Client{
-				Transport: &http.Transport{
-					Dial: (&net.Dialer{
-						LocalAddr: &net.TCPAddr{IP: defaultLocalAddr.IP, Zone: defaultLocalAddr.Zone},
-						KeepAlive: 3 * timeout,
-						Timeout:   timeout,
-					}).Dial,
-					ResponseHeaderTimeout: timeout,
-					MaxIdleConnsPerHost:   defaultConnections,
-				},
+			client, err := esclient.New(esclient.Default, esclient.Options{
+				Timeout:             timeout,
+				MaxIdleConnsPerHost: defaultConnections,
+				Debug:               debug,
+			})
+			if err != nil {
+				return err
 			}
+			clients <- client
 		}
 		errChan := make(chan error)
 		var hits HitsByCount
@@ -119,18 +114,15 @@ var RootCmd = &cobra.Command{
 				ctx, cancel := context.WithTimeout(context.Background(), timeout)
 				defer cancel()
 
-				req, err := http.NewRequest("GET", url, strings.NewReader(query))
+				req, err := esclient.NewRequest(ctx, "GET", url, query, nil)
 				if err != nil {
 					// If we can not create request, interrupt processing.
 					errChan <- err
 					return
 				}
-				req.WithContext(ctx)
 
 				if debug {
-					dReq, _ := httputil.DumpRequest(req, true)
 					fmt.Printf("Processing term: %s\n", term)
-					fmt.Println(string(dReq))
 				}
 
 				resp, err := client.Do(req)
@@ -141,11 +133,6 @@ var RootCmd = &cobra.Command{
 				}
 				defer resp.Body.Close()
 
-				if debug {
-					dResp, _ := httputil.DumpResponse(resp, true)
-					fmt.Println(string(dResp))
-				}
-
 				code := resp.StatusCode
 				if resp.StatusCode != http.StatusOK {
 					dReq, _ := httputil.DumpRequest(req, true)
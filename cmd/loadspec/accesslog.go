@@ -0,0 +1,364 @@
+package loadspec
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielfireman/esperf/anon"
+	"github.com/danielfireman/esperf/internal/cmdutil"
+	"github.com/danielfireman/esperf/loadspec"
+	"github.com/spf13/cobra"
+)
+
+const (
+	nginxLogFormat = "nginx"
+	envoyLogFormat = "envoy"
+	albLogFormat   = "alb"
+
+	// Nginx combined log format plus the request body.
+	defaultNginxFormat = `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent" "$request_body"`
+)
+
+var (
+	accessLogs  []string
+	logFormat   string
+	nginxFormat string
+	envoyFields []string
+)
+
+func init() {
+	addLogFlags(parseAccessLogCmd)
+	parseAccessLogCmd.Flags().StringSliceVar(&accessLogs, "access_logs", []string{}, "Access log files to be parsed. Reads from STDIN if not set.")
+	parseAccessLogCmd.Flags().StringVar(&logFormat, "log_format", nginxLogFormat, "Access log format: nginx, envoy (JSON) or alb (AWS Application Load Balancer, uncompressed).")
+	parseAccessLogCmd.Flags().StringVar(&nginxFormat, "nginx_format", defaultNginxFormat, "Nginx log_format definition. Supported variables: $time_local, $time_iso8601, $msec, $request, $request_method, $request_uri, $host, $http_host and $request_body. Other variables are ignored.")
+	parseAccessLogCmd.Flags().StringSliceVar(&envoyFields, "envoy_fields", []string{"time=start_time", "method=method", "path=path", "host=authority", "body=request_body"}, "Envoy JSON log keys, as field=key pairs. Fields are time, method, path, host and body.")
+}
+
+var parseAccessLogCmd = &cobra.Command{
+	Use:   "parseaccesslog [url]",
+	Short: "Outputs a replayable loadspec based on the passed-in HTTP access log and parameters.",
+	Long: `Outputs a replayable loadspec based on the passed-in HTTP access log (i.e. nginx or envoy proxies, or AWS
+application load balancers, in front of elasticsearch) and parameters. Each logged request becomes an entry,
+preserving method, URL, body and arrival times. ALB logs have no request bodies. If the URL argument is passed in,
+its scheme and host replace the logged ones.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var parser accessLogParser
+		var err error
+		switch logFormat {
+		case nginxLogFormat:
+			parser, err = newNginxParser(nginxFormat)
+		case envoyLogFormat:
+			parser, err = newEnvoyParser(envoyFields)
+		case albLogFormat:
+			parser = albParser{}
+		default:
+			err = fmt.Errorf("invalid log format:%q", logFormat)
+		}
+		if err != nil {
+			return err
+		}
+
+		urlArg := hostFromArgs(args)
+		header := cmdutil.NewHeader(cmd, args)
+		anonymizer, err := cmdutil.NewAnonymizer(anonymizedMap, anonFields, header)
+		if err != nil {
+			return err
+		}
+		in, closeLogs, err := openLogs(accessLogs, header)
+		if err != nil {
+			return err
+		}
+		defer closeLogs()
+
+		entries, skipped, err := readAccessLog(in, parser, urlArg, anonymizer)
+		if err != nil {
+			return err
+		}
+		if skipped > 0 {
+			fmt.Fprintf(os.Stderr, "%d requests could not be anonymized and were skipped.\n", skipped)
+		}
+		return writeTimestamped(entries, header)
+	},
+}
+
+// readAccessLog returns the entries of the logged requests, timestamped, and the number of requests skipped
+// because their bodies could not be anonymized. Bodies which are not JSON objects (i.e. msearch) are kept
+// as they are when there is no anonymization.
+func readAccessLog(in io.Reader, parser accessLogParser, urlArg string, anonymizer *anon.Anonymizer) (loadspec.ByDelaySinceLastNanos, int, error) {
+	var entries loadspec.ByDelaySinceLastNanos
+	skipped := 0
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	count := 0
+	for lineno := 1; scanner.Scan(); lineno++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		logEntry, err := parser.parse(scanner.Text())
+		if err != nil {
+			return nil, skipped, fmt.Errorf("line %d: %q", lineno, err)
+		}
+		entry := loadspec.Entry{}
+		if logEntry.method != "GET" {
+			entry.Method = logEntry.method
+		}
+		if logEntry.body != "" {
+			src, err := normalizeSource(logEntry.body, anonymizer)
+			switch {
+			case err == nil:
+				entry.Source = src
+			case anonymizer == nil:
+				entry.Source = logEntry.body
+			default:
+				// Can not be anonymized, not recorded to avoid leaking data.
+				skipped++
+				continue
+			}
+		}
+		// Keeping timestamp here for post-processing.
+		entry.DelaySinceLastNanos = logEntry.ts.UnixNano()
+
+		// Requests to proxies (and ALB logs) have absolute URIs, used as they are.
+		origin, uri := "", logEntry.uri
+		if u, err := url.Parse(uri); err == nil && u.IsAbs() {
+			origin, uri = u.Scheme+"://"+u.Host, u.RequestURI()
+		}
+		host := urlArg
+		if host == "" {
+			switch {
+			case origin != "":
+				host = origin
+			case logEntry.host != "":
+				host = "http://" + logEntry.host
+			default:
+				return nil, skipped, fmt.Errorf("line %d: log has no host information, please set the url argument", lineno)
+			}
+		}
+		entry.URL = host + overridePathIndex(uri, count)
+		entries = append(entries, &entry)
+		count++
+	}
+	return entries, skipped, scanner.Err()
+}
+
+// overridePathIndex replaces the index (first path segment) of the request URI, if --index_override is set.
+func overridePathIndex(uri string, count int) string {
+	if len(indexOverride) == 0 {
+		return uri
+	}
+	p := strings.SplitN(strings.TrimPrefix(uri, "/"), "/", 2)
+	if strings.HasPrefix(p[0], "_") {
+		// No index, i.e. /_search.
+		return uri
+	}
+	p[0] = overrideIndex(p[0], count)
+	return "/" + strings.Join(p, "/")
+}
+
+type accessLogEntry struct {
+	ts     time.Time
+	method string
+	host   string
+	uri    string
+	body   string
+}
+
+type accessLogParser interface {
+	parse(line string) (*accessLogEntry, error)
+}
+
+var nginxVarRE = regexp.MustCompile(`\$[a-z0-9_]+`)
+
+// nginxParser parses access logs following a nginx log_format definition. More at:
+// http://nginx.org/en/docs/http/ngx_http_log_module.html#log_format
+type nginxParser struct {
+	re    *regexp.Regexp
+	names []string
+}
+
+func newNginxParser(format string) (*nginxParser, error) {
+	vars := nginxVarRE.FindAllStringIndex(format, -1)
+	pattern := "^"
+	last := 0
+	for _, v := range vars {
+		pattern += regexp.QuoteMeta(format[last:v[0]])
+		pattern += fmt.Sprintf("(?P<%s>.*?)", format[v[0]+1:v[1]])
+		last = v[1]
+	}
+	pattern += regexp.QuoteMeta(format[last:]) + "$"
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nginx log format: %q", err)
+	}
+	p := &nginxParser{re: re, names: re.SubexpNames()}
+	has := make(map[string]bool)
+	for _, n := range p.names {
+		has[n] = true
+	}
+	if !has["time_local"] && !has["time_iso8601"] && !has["msec"] {
+		return nil, fmt.Errorf("nginx log format must have $time_local, $time_iso8601 or $msec")
+	}
+	if !has["request"] && !(has["request_method"] && has["request_uri"]) {
+		return nil, fmt.Errorf("nginx log format must have $request or $request_method and $request_uri")
+	}
+	return p, nil
+}
+
+func (p *nginxParser) parse(line string) (*accessLogEntry, error) {
+	m := p.re.FindStringSubmatch(line)
+	if m == nil {
+		return nil, fmt.Errorf("line does not match log format")
+	}
+	fields := make(map[string]string, len(m))
+	for i, v := range m {
+		if i > 0 {
+			fields[p.names[i]] = v
+		}
+	}
+	e := &accessLogEntry{
+		method: fields["request_method"],
+		uri:    fields["request_uri"],
+		host:   fields["host"],
+	}
+	if e.host == "" {
+		e.host = fields["http_host"]
+	}
+	if r, ok := fields["request"]; ok {
+		parts := strings.Fields(r)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("invalid request:%q", r)
+		}
+		e.method, e.uri = parts[0], parts[1]
+	}
+	var err error
+	switch {
+	case fields["msec"] != "":
+		var secs float64
+		secs, err = strconv.ParseFloat(fields["msec"], 64)
+		e.ts = time.Unix(0, int64(secs*1e9))
+	case fields["time_iso8601"] != "":
+		e.ts, err = time.Parse(time.RFC3339, fields["time_iso8601"])
+	default:
+		e.ts, err = time.Parse("02/Jan/2006:15:04:05 -0700", fields["time_local"])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid time: %q", err)
+	}
+	if body := fields["request_body"]; body != "-" {
+		e.body = unescapeNginx(body)
+	}
+	return e, nil
+}
+
+// unescapeNginx reverts nginx escaping of logged variables, which encodes '"', '\' and non-printable
+// characters as \xHH.
+func unescapeNginx(s string) string {
+	if !strings.Contains(s, `\x`) {
+		return s
+	}
+	var buf []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			if b, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				buf = append(buf, byte(b))
+				i += 3
+				continue
+			}
+		}
+		buf = append(buf, s[i])
+	}
+	return string(buf)
+}
+
+// envoyParser parses envoy JSON access logs. More at:
+// https://www.envoyproxy.io/docs/envoy/latest/configuration/observability/access_log/usage
+type envoyParser struct {
+	keys map[string]string
+}
+
+func newEnvoyParser(fields []string) (*envoyParser, error) {
+	p := &envoyParser{keys: make(map[string]string)}
+	for _, f := range fields {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid envoy field:%q", f)
+		}
+		switch kv[0] {
+		case "time", "method", "path", "host", "body":
+			p.keys[kv[0]] = kv[1]
+		default:
+			return nil, fmt.Errorf("invalid envoy field:%q", f)
+		}
+	}
+	if p.keys["time"] == "" || p.keys["path"] == "" {
+		return nil, fmt.Errorf("envoy fields must include time and path")
+	}
+	return p, nil
+}
+
+func (p *envoyParser) parse(line string) (*accessLogEntry, error) {
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &obj); err != nil {
+		return nil, err
+	}
+	get := func(field string) string {
+		// Envoy logs missing values as null or "-".
+		if s, ok := obj[p.keys[field]].(string); ok && s != "-" {
+			return s
+		}
+		return ""
+	}
+	ts, err := time.Parse(time.RFC3339, get("time"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid time: %q", err)
+	}
+	e := &accessLogEntry{
+		ts:     ts,
+		method: strings.ToUpper(get("method")),
+		uri:    get("path"),
+		host:   get("host"),
+		body:   get("body"),
+	}
+	if e.method == "" {
+		e.method = "GET"
+	}
+	return e, nil
+}
+
+var albFieldRE = regexp.MustCompile(`"[^"]*"|[^ ]+`)
+
+// albParser parses AWS application load balancer access logs, whose request field has an absolute URL. More at:
+// https://docs.aws.amazon.com/elasticloadbalancing/latest/application/load-balancer-access-logs.html
+type albParser struct{}
+
+func (albParser) parse(line string) (*accessLogEntry, error) {
+	fields := albFieldRE.FindAllString(line, -1)
+	if len(fields) < 13 {
+		return nil, fmt.Errorf("line does not match log format")
+	}
+	for i, f := range fields {
+		fields[i] = strings.Trim(f, `"`)
+	}
+	// Arrival time, if logged, otherwise the time the response was sent.
+	ts := fields[1]
+	if len(fields) > 21 && fields[21] != "-" {
+		ts = fields[21]
+	}
+	t, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time: %q", err)
+	}
+	parts := strings.Fields(fields[12])
+	if len(parts) < 2 || parts[0] == "-" {
+		return nil, fmt.Errorf("invalid request:%q", fields[12])
+	}
+	return &accessLogEntry{ts: t, method: parts[0], uri: parts[1]}, nil
+}
@@ -0,0 +1,126 @@
+package loadspec
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielfireman/esperf/anon"
+	"github.com/matryer/is"
+)
+
+func TestNginxParser(t *testing.T) {
+	is := is.New(t)
+	p, err := newNginxParser(defaultNginxFormat)
+	is.NoErr(err)
+	e, err := p.parse(`10.0.0.1 - - [18/Oct/2026:10:00:01 +0000] "POST /wiki/_search?size=1 HTTP/1.1" 200 42 "-" "curl/7.0" "{\x22query\x22:{\x22match_all\x22:{}}}"`)
+	is.NoErr(err)
+	is.Equal(e.method, "POST")
+	is.Equal(e.uri, "/wiki/_search?size=1")
+	is.Equal(e.body, `{"query":{"match_all":{}}}`)
+	is.Equal(e.ts, time.Date(2026, 10, 18, 10, 0, 1, 0, time.UTC).In(e.ts.Location()))
+
+	e, err = p.parse(`10.0.0.1 - - [18/Oct/2026:10:00:02 +0000] "GET /_cat/health HTTP/1.1" 200 42 "-" "curl/7.0" "-"`)
+	is.NoErr(err)
+	is.Equal(e.method, "GET")
+	is.Equal(e.body, "")
+
+	_, err = p.parse("garbage")
+	is.True(err != nil)
+}
+
+func TestNginxParser_CustomFormat(t *testing.T) {
+	is := is.New(t)
+	_, err := newNginxParser(`$remote_addr "$request"`)
+	is.True(err != nil) // No time.
+
+	p, err := newNginxParser(`$msec $host $request_method $request_uri $request_body`)
+	is.NoErr(err)
+	e, err := p.parse(`1760781601.500 es:9200 GET /wiki/_search {}`)
+	is.NoErr(err)
+	is.Equal(e.host, "es:9200")
+	is.Equal(e.uri, "/wiki/_search")
+	is.Equal(e.body, "{}")
+	is.Equal(e.ts.UnixNano(), int64(1760781601500000000))
+}
+
+func TestEnvoyParser(t *testing.T) {
+	is := is.New(t)
+	p, err := newEnvoyParser([]string{"time=start_time", "method=method", "path=path", "host=authority", "body=request_body"})
+	is.NoErr(err)
+	e, err := p.parse(`{"start_time":"2026-10-18T10:00:01.250Z","method":"POST","path":"/wiki/_search","authority":"es:9200","request_body":"{\"size\":0}"}`)
+	is.NoErr(err)
+	is.Equal(e.method, "POST")
+	is.Equal(e.host, "es:9200")
+	is.Equal(e.uri, "/wiki/_search")
+	is.Equal(e.body, `{"size":0}`)
+	is.Equal(e.ts, time.Date(2026, 10, 18, 10, 0, 1, 250000000, time.UTC))
+
+	e, err = p.parse(`{"start_time":"2026-10-18T10:00:01Z","path":"/_search","authority":"-","request_body":null}`)
+	is.NoErr(err)
+	is.Equal(e.method, "GET")
+	is.Equal(e.host, "")
+	is.Equal(e.body, "")
+
+	_, err = newEnvoyParser([]string{"foo=bar"})
+	is.True(err != nil)
+}
+
+func TestALBParser(t *testing.T) {
+	is := is.New(t)
+	var p albParser
+	e, err := p.parse(`https 2026-10-18T10:00:01.900000Z app/es-lb/50dc6c495c0c9188 192.168.131.39:2817 10.0.0.1:9200 0.000 0.880 0.000 200 200 34 366 "GET https://es.example.com:443/wiki/_search?q=brazil HTTP/1.1" "curl/7.46.0" ECDHE-RSA-AES128-GCM-SHA256 TLSv1.2 arn:aws:elasticloadbalancing:us-east-2:123456789012:targetgroup/es/73e2d6bc24d8a067 "Root=1-58337262-36d228ad5d99923122bbe354" "es.example.com" "-" 0 2026-10-18T10:00:01.020000Z "forward" "-" "-" "10.0.0.1:9200" "200" "-" "-"`)
+	is.NoErr(err)
+	is.Equal(e.method, "GET")
+	is.Equal(e.uri, "https://es.example.com:443/wiki/_search?q=brazil")
+	is.Equal(e.ts, time.Date(2026, 10, 18, 10, 0, 1, 20000000, time.UTC)) // Arrival time.
+
+	_, err = p.parse(`http 2026-10-18T10:00:01.900000Z app/es-lb/50dc6c495c0c9188 192.168.131.39:2817 - -1 -1 -1 400 - 0 0 "- http://es.example.com:80- -" "-" - - - "-" "-" "-" - 2026-10-18T10:00:01.020000Z "-" "-" "-" "-" "-" "-" "-"`)
+	is.True(err != nil) // Malformed request.
+	_, err = p.parse("garbage")
+	is.True(err != nil)
+}
+
+func TestReadAccessLog_AbsoluteURI(t *testing.T) {
+	is := is.New(t)
+	p, err := newNginxParser(defaultNginxFormat)
+	is.NoErr(err)
+	log := `10.0.0.1 - - [18/Oct/2026:10:00:01 +0000] "GET https://es:9243/wiki/_search?q=a HTTP/1.1" 200 42 "-" "curl/7.0" "-"`
+	entries, _, err := readAccessLog(strings.NewReader(log), p, "", nil)
+	is.NoErr(err)
+	is.Equal(entries[0].URL, "https://es:9243/wiki/_search?q=a")
+	entries, _, err = readAccessLog(strings.NewReader(log), p, "http://localhost:9200", nil)
+	is.NoErr(err)
+	is.Equal(entries[0].URL, "http://localhost:9200/wiki/_search?q=a")
+}
+
+func TestOverridePathIndex(t *testing.T) {
+	is := is.New(t)
+	indexOverride = []string{"other"}
+	defer func() { indexOverride = []string{} }()
+	is.Equal(overridePathIndex("/wiki/_search?q=a", 0), "/other/_search?q=a")
+	is.Equal(overridePathIndex("/_search", 0), "/_search")
+}
+
+func TestReadAccessLog_Msearch(t *testing.T) {
+	is := is.New(t)
+	p, err := newNginxParser(defaultNginxFormat)
+	is.NoErr(err)
+	log := `10.0.0.1 - - [18/Oct/2026:10:00:01 +0000] "POST /wiki/_search HTTP/1.1" 200 42 "-" "curl/7.0" "{\x22size\x22:0}"
+10.0.0.1 - - [18/Oct/2026:10:00:02 +0000] "POST /wiki/_msearch HTTP/1.1" 200 42 "-" "curl/7.0" "{}\x0A{\x22query\x22:{\x22match_all\x22:{}}}\x0A"
+`
+	entries, skipped, err := readAccessLog(strings.NewReader(log), p, "http://localhost:9200", nil)
+	is.NoErr(err)
+	is.Equal(skipped, 0)
+	is.Equal(len(entries), 2)
+	is.Equal(entries[0].Source, `{"size":0}`)
+	is.Equal(entries[1].Source, "{}\n{\"query\":{\"match_all\":{}}}\n") // Kept as it is.
+	is.Equal(entries[1].URL, "http://localhost:9200/wiki/_msearch")
+
+	// Bodies which can not be anonymized are skipped.
+	anonymizer := &anon.Anonymizer{FMap: anon.FieldsMap{}, FRE: anon.MustParseFieldsRE([]string{"name::(.*)"})}
+	entries, skipped, err = readAccessLog(strings.NewReader(log), p, "http://localhost:9200", anonymizer)
+	is.NoErr(err)
+	is.Equal(skipped, 1)
+	is.Equal(len(entries), 1)
+}
@@ -0,0 +1,47 @@
+package loadspec
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/danielfireman/esperf/loadspec"
+	"github.com/spf13/cobra"
+)
+
+var convertCmd = &cobra.Command{
+	Use:   "convert [loadspec]",
+	Short: "Converts a loadspec to the format set by --output_format.",
+	Long:  "Converts a loadspec to the format set by --output_format. The input format is detected automatically and the header is kept as is. Reads from STDIN if no file is passed in.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		in, err := openInput(args)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		reader, err := loadspec.NewReader(in)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		writer, err := loadspec.NewWriter(os.Stdout, outputFormat, reader.Header())
+		if err != nil {
+			return err
+		}
+		count := 0
+		for ; ; count++ {
+			entry, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if err := writer.Write(entry); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(os.Stderr, "Converted %d entries from %s to %s.\n", count, reader.Format(), outputFormat)
+		return writer.Close()
+	},
+}
@@ -0,0 +1,349 @@
+package loadspec
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/danielfireman/esperf/loadspec"
+	"github.com/spf13/cobra"
+)
+
+const (
+	k6Format    = "k6"
+	rallyFormat = "rally"
+)
+
+var (
+	exportFormat    string
+	exportOutputDir string
+	exportMaxVUs    int
+)
+
+func init() {
+	exportCmd.Flags().StringVar(&exportFormat, "format", "", "Format to export the loadspec to: vegeta (JSON targets), k6 (script) or rally (custom track).")
+	exportCmd.Flags().StringVar(&exportOutputDir, "output_dir", "", "Directory where the rally track is written to. Required by the rally format.")
+	exportCmd.Flags().IntVar(&exportMaxVUs, "max_vus", 100, "Maximum number of virtual users of the k6 scenario.")
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export [loadspec]",
+	Short: "Exports a loadspec to other load testing tools formats.",
+	Long: `Exports a loadspec to other load testing tools formats, preserving arrival times and query bodies as far as
+each format allows:
+ * vegeta: JSON targets, written to STDOUT. Vegeta has no per-target timing, the mean rate is suggested instead.
+ * k6: script written to STDOUT. Its arrival rate follows the number of entries of each second of the loadspec.
+ * rally: custom track written to --output_dir. It uses a custom scheduler which keeps the exact arrival times.
+Reads from STDIN if no file is passed in.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		in, err := openInput(args)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		_, entries, err := loadspec.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return fmt.Errorf("loadspec has no entries")
+		}
+
+		switch exportFormat {
+		case vegetaFormat:
+			writer := bufio.NewWriter(os.Stdout)
+			if err := exportVegeta(writer, entries); err != nil {
+				return err
+			}
+			return writer.Flush()
+		case k6Format:
+			writer := bufio.NewWriter(os.Stdout)
+			if err := exportK6(writer, entries, exportMaxVUs); err != nil {
+				return err
+			}
+			return writer.Flush()
+		case rallyFormat:
+			if exportOutputDir == "" {
+				return fmt.Errorf("rally format requires --output_dir")
+			}
+			return exportRally(exportOutputDir, entries)
+		default:
+			return fmt.Errorf("invalid format:%q", exportFormat)
+		}
+	},
+}
+
+func entryMethod(e *loadspec.Entry) string {
+	if e.Method == "" {
+		return "GET"
+	}
+	return e.Method
+}
+
+func specDuration(entries []loadspec.Entry) time.Duration {
+	var elapsed int64
+	for _, e := range entries {
+		elapsed += e.DelaySinceLastNanos
+	}
+	return time.Duration(elapsed)
+}
+
+type vegetaTarget struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Body   []byte      `json:"body,omitempty"`
+	Header http.Header `json:"header"`
+}
+
+// exportVegeta writes the entries as Vegeta JSON targets. More at: https://github.com/tsenart/vegeta#json-format
+func exportVegeta(w io.Writer, entries []loadspec.Entry) error {
+	enc := json.NewEncoder(w)
+	for i := range entries {
+		e := &entries[i]
+		t := vegetaTarget{
+			Method: entryMethod(e),
+			URL:    e.URL,
+			Header: http.Header{"Content-Type": []string{"application/json"}},
+		}
+		if e.Source != "" {
+			// encoding/json encodes []byte as base64, as expected by Vegeta.
+			t.Body = []byte(e.Source)
+		}
+		if err := enc.Encode(t); err != nil {
+			return err
+		}
+	}
+	d := specDuration(entries)
+	if d > 0 {
+		fmt.Fprintf(os.Stderr, "Suggested attack: vegeta attack -format=json -rate=%.0f/s -duration=%v\n", float64(len(entries))/d.Seconds(), d)
+	}
+	return nil
+}
+
+type k6Stage struct {
+	Duration string `json:"duration"`
+	Target   int    `json:"target"`
+}
+
+// k6Stages returns ramping-arrival-rate stages which follow the number of entries of each second of the
+// loadspec. Stages come in pairs: an immediate jump to the rate followed by a constant period.
+func k6Stages(entries []loadspec.Entry) []k6Stage {
+	var perSecond []int
+	var elapsed int64
+	for _, e := range entries {
+		elapsed += e.DelaySinceLastNanos
+		s := int(elapsed / int64(time.Second))
+		for len(perSecond) <= s {
+			perSecond = append(perSecond, 0)
+		}
+		perSecond[s]++
+	}
+	var stages []k6Stage
+	for i := 0; i < len(perSecond); {
+		j := i
+		for j < len(perSecond) && perSecond[j] == perSecond[i] {
+			j++
+		}
+		stages = append(stages,
+			k6Stage{Duration: "0s", Target: perSecond[i]},
+			k6Stage{Duration: fmt.Sprintf("%ds", j-i), Target: perSecond[i]})
+		i = j
+	}
+	return stages
+}
+
+type k6Request struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Body   string `json:"body"`
+}
+
+var k6Template = template.Must(template.New("k6").Parse(`// Generated by esperf loadspec export.
+import http from 'k6/http';
+import exec from 'k6/execution';
+
+const requests = {{.Requests}};
+
+const params = { headers: { 'Content-Type': 'application/json' } };
+
+export const options = {
+  scenarios: {
+    esperf: {
+      executor: 'ramping-arrival-rate',
+      startRate: 0,
+      timeUnit: '1s',
+      preAllocatedVUs: {{.PreAllocatedVUs}},
+      maxVUs: {{.MaxVUs}},
+      stages: {{.Stages}},
+    },
+  },
+};
+
+export default function () {
+  const r = requests[exec.scenario.iterationInTest % requests.length];
+  http.request(r.method, r.url, r.body, params);
+}
+`))
+
+// exportK6 writes a k6 script which replays the loadspec requests in order. More at: https://k6.io/docs/
+func exportK6(w io.Writer, entries []loadspec.Entry, maxVUs int) error {
+	requests := make([]k6Request, len(entries))
+	for i := range entries {
+		requests[i] = k6Request{Method: entryMethod(&entries[i]), URL: entries[i].URL, Body: entries[i].Source}
+	}
+	reqBuf, err := json.MarshalIndent(requests, "", "  ")
+	if err != nil {
+		return err
+	}
+	stagesBuf, err := json.MarshalIndent(k6Stages(entries), "      ", "  ")
+	if err != nil {
+		return err
+	}
+	preAllocated := 10
+	if maxVUs < preAllocated {
+		preAllocated = maxVUs
+	}
+	return k6Template.Execute(w, map[string]interface{}{
+		"Requests":        string(reqBuf),
+		"Stages":          string(stagesBuf),
+		"MaxVUs":          maxVUs,
+		"PreAllocatedVUs": preAllocated,
+	})
+}
+
+type rallyRequest struct {
+	DelaySinceLastNanos int64             `json:"delay_since_last_nanos"`
+	Index               string            `json:"index,omitempty"`
+	Body                json.RawMessage   `json:"body,omitempty"`
+	RequestParams       map[string]string `json:"request-params,omitempty"`
+}
+
+const rallyTrack = `{
+  "version": 2,
+  "description": "Replays a loadspec exported by esperf",
+  "operations": [
+    {
+      "name": "esperf-replay",
+      "operation-type": "search",
+      "param-source": "esperf-replay"
+    }
+  ],
+  "challenges": [
+    {
+      "name": "esperf-replay",
+      "default": true,
+      "schedule": [
+        {
+          "operation": "esperf-replay",
+          "clients": 1,
+          "warmup-iterations": 0,
+          "iterations": %d,
+          "schedule": "esperf-replay"
+        }
+      ]
+    }
+  ]
+}
+`
+
+const rallyTrackPy = `# Generated by esperf loadspec export.
+import json
+import os
+
+REQUESTS_FILE = os.path.join(os.path.dirname(os.path.abspath(__file__)), "requests.json")
+
+
+def load_requests():
+    with open(REQUESTS_FILE) as f:
+        return [json.loads(line) for line in f if line.strip()]
+
+
+class ReplayParamSource:
+    def __init__(self, track, params, **kwargs):
+        self._requests = load_requests()
+        self._i = 0
+
+    def partition(self, partition_index, total_partitions):
+        return self
+
+    def params(self):
+        r = self._requests[self._i % len(self._requests)]
+        self._i += 1
+        return {
+            "index": r.get("index", "_all"),
+            "body": r.get("body"),
+            "request-params": r.get("request-params", {}),
+            "cache": False,
+        }
+
+
+class ReplayScheduler:
+    # Keeps the loadspec arrival times, in seconds since the beginning of the task.
+    def __init__(self, *args, **kwargs):
+        self._arrivals = []
+        elapsed = 0
+        for r in load_requests():
+            elapsed += r["delay_since_last_nanos"]
+            self._arrivals.append(elapsed / 1e9)
+        self._i = 0
+
+    def next(self, current):
+        arrival = self._arrivals[min(self._i, len(self._arrivals) - 1)]
+        self._i += 1
+        return arrival
+
+
+def register(registry):
+    registry.register_param_source("esperf-replay", ReplayParamSource)
+    registry.register_scheduler("esperf-replay", ReplayScheduler)
+`
+
+// exportRally writes a Rally custom track to dir. More at: https://esrally.readthedocs.io/en/stable/adding_tracks.html
+func exportRally(dir string, entries []loadspec.Entry) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(dir, "requests.json"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	writer := bufio.NewWriter(f)
+	enc := json.NewEncoder(writer)
+	for _, e := range entries {
+		u, err := url.Parse(e.URL)
+		if err != nil {
+			return err
+		}
+		r := rallyRequest{
+			DelaySinceLastNanos: e.DelaySinceLastNanos,
+			Index:               strings.Join(indicesFromPath(u.Path), ","),
+			RequestParams:       make(map[string]string),
+		}
+		if e.Source != "" {
+			r.Body = json.RawMessage(e.Source)
+		}
+		for k, v := range u.Query() {
+			r.RequestParams[k] = v[0]
+		}
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "track.json"), []byte(fmt.Sprintf(rallyTrack, len(entries))), 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "track.py"), []byte(rallyTrackPy), 0644)
+}
@@ -0,0 +1,53 @@
+package loadspec
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielfireman/esperf/loadspec"
+	"github.com/matryer/is"
+)
+
+func TestExportVegeta(t *testing.T) {
+	is := is.New(t)
+	var buf bytes.Buffer
+	is.NoErr(exportVegeta(&buf, []loadspec.Entry{
+		{URL: "http://localhost:9200/index/_search", Source: `{"size":0}`},
+		{URL: "http://localhost:9200/index/_search", Method: "POST"},
+	}))
+	// Round trip using the importer.
+	requests, err := parseVegeta(&buf)
+	is.NoErr(err)
+	is.Equal(len(requests), 2)
+	is.Equal(requests[0].method, "GET")
+	is.Equal(requests[0].body, `{"size":0}`)
+	is.Equal(requests[1].method, "POST")
+}
+
+func TestK6Stages(t *testing.T) {
+	is := is.New(t)
+	s := int64(time.Second)
+	stages := k6Stages([]loadspec.Entry{
+		{DelaySinceLastNanos: 0},
+		{DelaySinceLastNanos: s / 2},
+		{DelaySinceLastNanos: s / 2},
+		{DelaySinceLastNanos: s / 2},
+		{DelaySinceLastNanos: 2 * s},
+	})
+	is.Equal(stages, []k6Stage{
+		{"0s", 2}, {"2s", 2},
+		{"0s", 0}, {"1s", 0},
+		{"0s", 1}, {"1s", 1},
+	})
+}
+
+func TestExportK6(t *testing.T) {
+	is := is.New(t)
+	var buf bytes.Buffer
+	is.NoErr(exportK6(&buf, []loadspec.Entry{{URL: "http://localhost:9200/index/_search", Source: `{"size":0}`}}, 5))
+	is.True(strings.Contains(buf.String(), `"url": "http://localhost:9200/index/_search"`))
+	is.True(strings.Contains(buf.String(), `"body": "{\"size\":0}"`))
+	is.True(strings.Contains(buf.String(), "maxVUs: 5,"))
+}
@@ -2,7 +2,6 @@ package loadspec
 
 import (
 	"bufio"
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -10,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/danielfireman/esperf/internal/cmdutil"
 	"github.com/danielfireman/esperf/loadspec"
 	"github.com/spf13/cobra"
 )
@@ -40,7 +40,7 @@ var genLoadspec = &cobra.Command{
 			return fmt.Errorf("please set the url argument.")
 		}
 		url := args[0]
-		iaGen, err := newInterArrival(arrivalSpec)
+		iaGen, err := loadspec.NewInterArrival(arrivalSpec, randGen)
 		if err != nil {
 			return err
 		}
@@ -72,19 +72,24 @@ var genLoadspec = &cobra.Command{
 				return fmt.Errorf("query defintion uses $RDICT and dictionary is empty.")
 			}
 		}
-		// Writer and encoding configuration.
-		writer := bufio.NewWriter(os.Stdout)
-		defer writer.Flush()
-		enc := json.NewEncoder(writer)
+		header := cmdutil.NewHeader(cmd, args)
+		header.Seed = seed
+		if dict != "" {
+			header.Sources = []string{dict}
+		}
+		writer, err := loadspec.NewWriter(os.Stdout, outputFormat, header)
+		if err != nil {
+			return err
+		}
 
 		finalTime := duration.Nanoseconds()
 		ia := int64(0)
 		entry := loadspec.Entry{}
 		hasTerms := len(terms) > 0
-        id := 0
+		id := 0
 		for currTime := int64(0); currTime <= finalTime; currTime += ia {
-            entry.ID = id
-            id++
+			entry.ID = id
+			id++
 			entry.DelaySinceLastNanos = ia
 			entry.URL = url
 			if hasTerms {
@@ -92,11 +97,11 @@ var genLoadspec = &cobra.Command{
 			} else {
 				entry.Source = query
 			}
-			if err := enc.Encode(entry); err != nil {
+			if err := writer.Write(&entry); err != nil {
 				return err
 			}
 			ia = iaGen.Next()
 		}
-		return nil
+		return writer.Close()
 	},
 }
@@ -0,0 +1,411 @@
+package loadspec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielfireman/esperf/internal/cmdutil"
+	"github.com/danielfireman/esperf/loadspec"
+	"github.com/spf13/cobra"
+)
+
+const (
+	vegetaFormat = "vegeta"
+	jtlFormat    = "jtl"
+	harFormat    = "har"
+	ammoFormat   = "ammo"
+
+	// Largest ammo request accepted, the default maximum request size of Elasticsearch (http.max_content_length).
+	maxAmmoSize = 100 << 20
+)
+
+var (
+	importFormat      string
+	importArrivalSpec string
+	importHost        string
+)
+
+func init() {
+	importCmd.Flags().StringVar(&importFormat, "format", "", "Format of the trace to import: vegeta (http or json targets), jtl (JMeter CSV or XML results), har or ammo (Yandex-Tank phantom ammo).")
+	importCmd.Flags().StringVar(&importArrivalSpec, "arrival_spec", "", "Inter arrival time specification used when the trace has no timestamps (vegeta and ammo).")
+	importCmd.Flags().StringVar(&importHost, "host", "", "Replaces the scheme and host of the imported requests (i.e. http://localhost:9200). Required by ammo traces without Host header.")
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import [trace]",
+	Short: "Outputs a replayable loadspec based on a trace from another load testing tool.",
+	Long: `Outputs a replayable loadspec based on a trace from another load testing tool. Supported formats are Vegeta
+targets, JMeter JTL results, HAR captures and Yandex-Tank phantom ammo. Timestamps, when present, are kept as
+inter-arrival delays. Otherwise, delays follow --arrival_spec. Per-request headers are not imported.
+Reads from STDIN if no file is passed in.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var parse func(io.Reader) ([]importedRequest, error)
+		switch importFormat {
+		case vegetaFormat:
+			parse = parseVegeta
+		case jtlFormat:
+			parse = parseJTL
+		case harFormat:
+			parse = parseHAR
+		case ammoFormat:
+			parse = parseAmmo
+		default:
+			return fmt.Errorf("invalid format:%q", importFormat)
+		}
+		in, err := openInput(args)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		requests, err := parse(in)
+		if err != nil {
+			return err
+		}
+
+		var iaGen loadspec.InterArrival
+		if importArrivalSpec != "" {
+			if iaGen, err = loadspec.NewInterArrival(importArrivalSpec, randGen); err != nil {
+				return err
+			}
+		}
+		entries, err := toEntries(requests, iaGen)
+		if err != nil {
+			return err
+		}
+		if importHost != "" {
+			for i := range entries {
+				if entries[i].URL, err = replaceHost(entries[i].URL, importHost); err != nil {
+					return err
+				}
+			}
+		}
+
+		header := cmdutil.NewHeader(cmd, args)
+		header.Seed = seed
+		if len(args) > 0 {
+			header.Sources = []string{filepath.Base(args[0])}
+		}
+		return loadspec.WriteAll(os.Stdout, outputFormat, header, entries)
+	},
+}
+
+// importedRequest is a request parsed from another tool trace. Zero timestamp means the trace does not
+// have timing information.
+type importedRequest struct {
+	ts     time.Time
+	method string
+	url    string
+	body   string
+}
+
+// toEntries converts imported requests into loadspec entries. If requests have timestamps, they are kept
+// as inter-arrival delays. Otherwise, delays are generated by iaGen.
+func toEntries(requests []importedRequest, iaGen loadspec.InterArrival) ([]loadspec.Entry, error) {
+	hasTimestamps := len(requests) > 0 && !requests[0].ts.IsZero()
+	if !hasTimestamps && iaGen == nil && len(requests) > 1 {
+		return nil, fmt.Errorf("trace has no timestamps, please set --arrival_spec")
+	}
+	if hasTimestamps {
+		sort.SliceStable(requests, func(i, j int) bool {
+			return requests[i].ts.Before(requests[j].ts)
+		})
+	}
+	entries := make([]loadspec.Entry, len(requests))
+	for i, r := range requests {
+		entries[i] = loadspec.Entry{ID: i, URL: r.url, Source: r.body}
+		if r.method != "GET" {
+			entries[i].Method = r.method
+		}
+		if i == 0 {
+			continue
+		}
+		if hasTimestamps {
+			entries[i].DelaySinceLastNanos = r.ts.Sub(requests[i-1].ts).Nanoseconds()
+		} else {
+			entries[i].DelaySinceLastNanos = iaGen.Next()
+		}
+	}
+	return entries, nil
+}
+
+// replaceHost replaces scheme and host of rawURL by the ones in host.
+func replaceHost(rawURL, host string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	h, err := url.Parse(host)
+	if err != nil {
+		return "", err
+	}
+	u.Scheme = h.Scheme
+	u.Host = h.Host
+	return u.String(), nil
+}
+
+// firstByte returns the first non-whitespace byte of the reader, without consuming it.
+func firstByte(r *bufio.Reader) byte {
+	for i := 1; ; i++ {
+		buf, err := r.Peek(i)
+		if len(buf) < i || err != nil {
+			return 0
+		}
+		if c := buf[i-1]; c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			return c
+		}
+	}
+}
+
+// parseVegeta parses Vegeta targets, both http and json formats. More at:
+// https://github.com/tsenart/vegeta#-format
+func parseVegeta(in io.Reader) ([]importedRequest, error) {
+	r := bufio.NewReader(in)
+	if firstByte(r) == '{' {
+		return parseVegetaJSON(r)
+	}
+	var requests []importedRequest
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	var current *importedRequest
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "@"):
+			if current == nil {
+				return nil, fmt.Errorf("line %d: body without target", lineno)
+			}
+			body, err := ioutil.ReadFile(strings.TrimPrefix(line, "@"))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %q", lineno, err)
+			}
+			current.body = string(body)
+		default:
+			parts := strings.SplitN(line, " ", 2)
+			if len(parts) == 2 && (strings.HasPrefix(parts[1], "http://") || strings.HasPrefix(parts[1], "https://")) {
+				requests = append(requests, importedRequest{method: strings.ToUpper(parts[0]), url: strings.TrimSpace(parts[1])})
+				current = &requests[len(requests)-1]
+				continue
+			}
+			if current == nil || !strings.Contains(line, ":") {
+				return nil, fmt.Errorf("line %d: invalid target:%q", lineno, line)
+			}
+			// Headers are not kept.
+		}
+	}
+	return requests, scanner.Err()
+}
+
+func parseVegetaJSON(r io.Reader) ([]importedRequest, error) {
+	var requests []importedRequest
+	dec := json.NewDecoder(r)
+	for {
+		var t struct {
+			Method string `json:"method"`
+			URL    string `json:"url"`
+			// Vegeta encodes bodies as base64, which encoding/json decodes into []byte.
+			Body []byte `json:"body"`
+		}
+		if err := dec.Decode(&t); err == io.EOF {
+			return requests, nil
+		} else if err != nil {
+			return nil, err
+		}
+		requests = append(requests, importedRequest{method: strings.ToUpper(t.Method), url: t.URL, body: string(t.Body)})
+	}
+}
+
+// parseJTL parses JMeter results, both CSV (with header row) and XML formats. Timestamps must be
+// milliseconds since epoch, which is JMeter's default.
+func parseJTL(in io.Reader) ([]importedRequest, error) {
+	r := bufio.NewReader(in)
+	if firstByte(r) == '<' {
+		return parseJTLXML(r)
+	}
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	tsCol, urlCol := -1, -1
+	for i, h := range header {
+		switch h {
+		case "timeStamp":
+			tsCol = i
+		case "URL":
+			urlCol = i
+		}
+	}
+	if tsCol < 0 || urlCol < 0 {
+		return nil, fmt.Errorf("JTL must have timeStamp and URL columns")
+	}
+	var requests []importedRequest
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return requests, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) <= tsCol || len(record) <= urlCol {
+			return nil, fmt.Errorf("invalid JTL record:%q", record)
+		}
+		ts, err := parseMillis(record[tsCol])
+		if err != nil {
+			return nil, err
+		}
+		// Non-HTTP samples (i.e. transaction controllers) have no URL.
+		if record[urlCol] == "" || record[urlCol] == "null" {
+			continue
+		}
+		requests = append(requests, importedRequest{ts: ts, method: "GET", url: record[urlCol]})
+	}
+}
+
+type jtlSample struct {
+	Timestamp   string `xml:"ts,attr"`
+	Method      string `xml:"method"`
+	URL         string `xml:"java.net.URL"`
+	QueryString string `xml:"queryString"`
+}
+
+func parseJTLXML(r io.Reader) ([]importedRequest, error) {
+	var requests []importedRequest
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return requests, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "httpSample" {
+			continue
+		}
+		var s jtlSample
+		if err := dec.DecodeElement(&s, &start); err != nil {
+			return nil, err
+		}
+		ts, err := parseMillis(s.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		method := strings.ToUpper(s.Method)
+		if method == "" {
+			method = "GET"
+		}
+		req := importedRequest{ts: ts, method: method, url: s.URL}
+		// For requests with body, JMeter saves the body as the query string.
+		if method != "GET" || strings.HasPrefix(strings.TrimSpace(s.QueryString), "{") {
+			req.body = s.QueryString
+		}
+		requests = append(requests, req)
+	}
+}
+
+func parseMillis(s string) (time.Time, error) {
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp:%q", s)
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), nil
+}
+
+type harLog struct {
+	Log struct {
+		Entries []struct {
+			StartedDateTime time.Time `json:"startedDateTime"`
+			Request         struct {
+				Method   string `json:"method"`
+				URL      string `json:"url"`
+				PostData struct {
+					Text string `json:"text"`
+				} `json:"postData"`
+			} `json:"request"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+// parseHAR parses HTTP Archive captures. More at: http://www.softwareishard.com/blog/har-12-spec/
+func parseHAR(in io.Reader) ([]importedRequest, error) {
+	var har harLog
+	if err := json.NewDecoder(in).Decode(&har); err != nil {
+		return nil, err
+	}
+	var requests []importedRequest
+	for _, e := range har.Log.Entries {
+		requests = append(requests, importedRequest{
+			ts:     e.StartedDateTime,
+			method: strings.ToUpper(e.Request.Method),
+			url:    e.Request.URL,
+			body:   e.Request.PostData.Text,
+		})
+	}
+	return requests, nil
+}
+
+// parseAmmo parses Yandex-Tank phantom ammo, where each request is preceded by a line containing its size
+// in bytes and an optional tag. More at: http://yandextank.readthedocs.io/en/latest/tutorial.html
+func parseAmmo(in io.Reader) ([]importedRequest, error) {
+	r := bufio.NewReader(in)
+	var requests []importedRequest
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF && strings.TrimSpace(line) == "" {
+			return requests, nil
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		size, err := strconv.Atoi(strings.Fields(line)[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid ammo size line:%q", line)
+		}
+		if size < 0 || size > maxAmmoSize {
+			return nil, fmt.Errorf("invalid ammo size:%d, must be between 0 and %d bytes", size, maxAmmoSize)
+		}
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid ammo request:%q", err)
+		}
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		u := "http://" + req.Host + req.RequestURI
+		if req.Host == "" {
+			if importHost == "" {
+				return nil, fmt.Errorf("ammo request without Host header, please set --host")
+			}
+			u = strings.TrimRight(importHost, "/") + req.RequestURI
+		}
+		requests = append(requests, importedRequest{method: req.Method, url: u, body: string(body)})
+	}
+}
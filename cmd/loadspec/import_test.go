@@ -0,0 +1,123 @@
+package loadspec
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielfireman/esperf/loadspec"
+	"github.com/matryer/is"
+)
+
+func TestParseVegeta(t *testing.T) {
+	is := is.New(t)
+	requests, err := parseVegeta(strings.NewReader(`
+# Comment
+GET http://localhost:9200/index/_search?q=foo
+Content-Type: application/json
+
+POST http://localhost:9200/index/_count
+`))
+	is.NoErr(err)
+	is.Equal(len(requests), 2)
+	is.Equal(requests[0].method, "GET")
+	is.Equal(requests[0].url, "http://localhost:9200/index/_search?q=foo")
+	is.Equal(requests[1].method, "POST")
+	is.True(requests[1].ts.IsZero())
+}
+
+func TestParseVegeta_JSON(t *testing.T) {
+	is := is.New(t)
+	// Body is base64 of {"size":0}
+	requests, err := parseVegeta(strings.NewReader(`{"method":"POST","url":"http://localhost:9200/index/_search","body":"eyJzaXplIjowfQ=="}
+{"method":"GET","url":"http://localhost:9200/index/_search"}`))
+	is.NoErr(err)
+	is.Equal(len(requests), 2)
+	is.Equal(requests[0].body, `{"size":0}`)
+	is.Equal(requests[1].method, "GET")
+}
+
+func TestParseJTL(t *testing.T) {
+	is := is.New(t)
+	requests, err := parseJTL(strings.NewReader(`timeStamp,elapsed,label,responseCode,URL
+1500000000000,10,search,200,http://localhost:9200/index/_search
+1500000000250,10,search,200,http://localhost:9200/index/_search
+1500000000300,10,transaction,200,null
+`))
+	is.NoErr(err)
+	is.Equal(len(requests), 2)
+	is.Equal(requests[1].ts.Sub(requests[0].ts), 250*time.Millisecond)
+	is.Equal(requests[0].url, "http://localhost:9200/index/_search")
+}
+
+func TestParseJTL_XML(t *testing.T) {
+	is := is.New(t)
+	requests, err := parseJTL(strings.NewReader(`<?xml version="1.0" encoding="UTF-8"?>
+<testResults version="1.2">
+<httpSample t="10" ts="1500000000000" lb="search" rc="200">
+  <method class="java.lang.String">POST</method>
+  <queryString class="java.lang.String">{"query":{"match_all":{}}}</queryString>
+  <java.net.URL>http://localhost:9200/index/_search</java.net.URL>
+</httpSample>
+<httpSample t="10" ts="1500000001000" lb="search" rc="200">
+  <java.net.URL>http://localhost:9200/index/_search?q=foo</java.net.URL>
+</httpSample>
+</testResults>`))
+	is.NoErr(err)
+	is.Equal(len(requests), 2)
+	is.Equal(requests[0].method, "POST")
+	is.Equal(requests[0].body, `{"query":{"match_all":{}}}`)
+	is.Equal(requests[0].url, "http://localhost:9200/index/_search")
+	is.Equal(requests[1].method, "GET")
+	is.Equal(requests[1].ts.Sub(requests[0].ts), time.Second)
+}
+
+func TestParseHAR(t *testing.T) {
+	is := is.New(t)
+	requests, err := parseHAR(strings.NewReader(`{"log":{"entries":[
+{"startedDateTime":"2017-07-10T13:04:23.500Z","request":{"method":"POST","url":"http://localhost:9200/index/_search","postData":{"text":"{}"}}},
+{"startedDateTime":"2017-07-10T13:04:23.000Z","request":{"method":"GET","url":"http://localhost:9200/index/_count"}}
+]}}`))
+	is.NoErr(err)
+	is.Equal(len(requests), 2)
+	is.Equal(requests[0].body, "{}")
+
+	entries, err := toEntries(requests, nil)
+	is.NoErr(err)
+	is.Equal(entries[0].URL, "http://localhost:9200/index/_count")
+	is.Equal(entries[0].Method, "")
+	is.Equal(entries[1].Method, "POST")
+	is.Equal(entries[1].DelaySinceLastNanos, int64(500*time.Millisecond))
+}
+
+func TestParseAmmo(t *testing.T) {
+	is := is.New(t)
+	req := "POST /index/_search HTTP/1.1\r\nHost: localhost:9200\r\nContent-Length: 2\r\n\r\n{}"
+	requests, err := parseAmmo(strings.NewReader("75 search\n" + req + "\n"))
+	is.NoErr(err)
+	is.Equal(len(requests), 1)
+	is.Equal(requests[0].method, "POST")
+	is.Equal(requests[0].url, "http://localhost:9200/index/_search")
+	is.Equal(requests[0].body, "{}")
+
+	// Sizes are checked before allocating the request buffer.
+	_, err = parseAmmo(strings.NewReader("-1 search\n" + req + "\n"))
+	is.True(err != nil)
+	_, err = parseAmmo(strings.NewReader("9223372036854775807 search\n" + req + "\n"))
+	is.True(err != nil)
+}
+
+func TestToEntries_WithoutTimestamps(t *testing.T) {
+	is := is.New(t)
+	requests := []importedRequest{{method: "GET", url: "a"}, {method: "GET", url: "b"}}
+	_, err := toEntries(requests, nil)
+	is.True(err != nil)
+
+	iaGen, err := loadspec.NewInterArrival("const:10", nil)
+	is.NoErr(err)
+	entries, err := toEntries(requests, iaGen)
+	is.NoErr(err)
+	is.Equal(entries[0].DelaySinceLastNanos, int64(0))
+	is.Equal(entries[1].DelaySinceLastNanos, int64(100*time.Millisecond))
+	is.Equal(entries[1].ID, 1)
+}
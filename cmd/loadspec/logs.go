@@ -0,0 +1,141 @@
+package loadspec
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/danielfireman/esperf/anon"
+	"github.com/danielfireman/esperf/loadspec"
+	"github.com/spf13/cobra"
+)
+
+// Flags and helpers shared by commands which build loadspecs out of logs.
+
+var (
+	indexOverride []string
+	maxDuration   time.Duration
+	anonymizedMap string
+	anonFields    []string
+)
+
+func addLogFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&indexOverride, "index_override", []string{}, "Override logged indexes. It is a list, flag could be repeated if you would the loadtest to hit many indexes.")
+	cmd.Flags().DurationVar(&maxDuration, "max_duration", time.Duration(0), "Maximum duration of the generated loadspec. It could be smaller, if the log comprise a smaller time frame.")
+	cmd.Flags().StringVar(&anonymizedMap, "anonymized_map_path", "", "Path to the dictionary of anonymized fields.")
+	cmd.Flags().StringSliceVar(&anonFields, "anon_fields", []string{}, "Name of the fields in the source document that must be anonymized. Only accept numbers and strings.")
+}
+
+// hostFromArgs returns the scheme and host or host:port part of the URL argument, if any.
+func hostFromArgs(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	// To keep in par with gen, we only consider the host or host:port part of the URL.
+	urlArg := args[0]
+	prefix := ""
+	switch {
+	case strings.HasPrefix(urlArg, "http://"):
+		urlArg = strings.TrimPrefix(urlArg, "http://")
+		prefix = "http://"
+	case strings.HasPrefix(urlArg, "https://"):
+		urlArg = strings.TrimPrefix(urlArg, "https://")
+		prefix = "https://"
+	}
+	i := strings.Index(urlArg, "/")
+	if i > 0 {
+		urlArg = urlArg[:i]
+	}
+	return prefix + urlArg
+}
+
+// openLogs returns a reader which concatenates the passed-in files, recording their names in the header.
+// Falls back to STDIN if no path is passed in. Callers must call close when done.
+func openLogs(paths []string, header *loadspec.Header) (io.Reader, func(), error) {
+	if len(paths) == 0 {
+		return os.Stdin, func() {}, nil
+	}
+	var files []*os.File
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	var readers []io.Reader
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		files = append(files, f)
+		readers = append(readers, f)
+		header.Sources = append(header.Sources, filepath.Base(path))
+	}
+	return io.MultiReader(readers...), closeAll, nil
+}
+
+// normalizeSource validates, anonymizes (if anonymizer is not nil) and sorts the fields of the source.
+func normalizeSource(source string, anonymizer *anon.Anonymizer) (string, error) {
+	// Even though unmarshal and marshal consumes more CPU, it validates the source and sorts the fields,
+	// which makes comparison much easier.
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(source), &obj); err != nil {
+		return "", err
+	}
+	if anonymizer != nil {
+		anonymizer.Anonymize(obj)
+	}
+	src, err := json.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+	return string(src), nil
+}
+
+// overrideIndex returns the index to be used by the count-th entry.
+func overrideIndex(index string, count int) string {
+	if len(indexOverride) > 0 {
+		return indexOverride[count%len(indexOverride)]
+	}
+	return index
+}
+
+// writeTimestamped writes the entries to STDOUT. Entries DelaySinceLastNanos must hold their timestamp
+// (nanoseconds since epoch), which is converted to the delay since last entry. The loadspec is truncated
+// at --max_duration.
+func writeTimestamped(entries loadspec.ByDelaySinceLastNanos, header *loadspec.Header) error {
+	// Log entries are not guaranteed to be timestamp ordered.
+	sort.Stable(entries)
+
+	writer, err := loadspec.NewWriter(os.Stdout, outputFormat, header)
+	if err != nil {
+		return err
+	}
+	var elapsed, previousTimestamp, currTimestamp int64
+	for i, e := range entries {
+		e.ID = i
+		// Adjusting from timestamp to delay since last request. That makes a lot easier to replay.
+		currTimestamp = e.DelaySinceLastNanos
+		if i == 0 {
+			e.DelaySinceLastNanos = 0
+		} else {
+			e.DelaySinceLastNanos -= previousTimestamp
+		}
+		previousTimestamp = currTimestamp
+		if err := writer.Write(e); err != nil {
+			return err
+		}
+		elapsed += e.DelaySinceLastNanos
+		if maxDuration.Nanoseconds() > 0 && elapsed >= maxDuration.Nanoseconds() {
+			break
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Test duration: %v\n", time.Duration(elapsed))
+	return writer.Close()
+}
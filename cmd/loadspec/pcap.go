@@ -0,0 +1,429 @@
+package loadspec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/danielfireman/esperf/anon"
+	"github.com/danielfireman/esperf/internal/cmdutil"
+	"github.com/danielfireman/esperf/loadspec"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pcaps    []string
+	pcapPort int
+)
+
+func init() {
+	addLogFlags(parsePcapCmd)
+	parsePcapCmd.Flags().StringSliceVar(&pcaps, "pcaps", []string{}, "Pcap files to be parsed. Reads from STDIN if not set.")
+	parsePcapCmd.Flags().IntVar(&pcapPort, "port", 9200, "Elasticsearch TCP port. Only packets sent to this port are considered.")
+}
+
+var parsePcapCmd = &cobra.Command{
+	Use:   "parsepcap [url]",
+	Short: "Outputs a replayable loadspec based on the passed-in packet capture and parameters.",
+	Long: `Outputs a replayable loadspec based on the passed-in packet capture (i.e. tcpdump -w capture.pcap port 9200)
+and parameters. HTTP/1.1 requests are reassembled from TCP segments, including keep-alive connections, and arrival
+times come from packet timestamps. Only the classic pcap format is supported, pcapng files can be converted
+using editcap -F pcap. If the URL argument is passed in, its scheme and host replace the captured ones.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		urlArg := hostFromArgs(args)
+		header := cmdutil.NewHeader(cmd, args)
+		anonymizer, err := cmdutil.NewAnonymizer(anonymizedMap, anonFields, header)
+		if err != nil {
+			return err
+		}
+
+		var requests []*capturedRequest
+		if len(pcaps) == 0 {
+			if requests, err = readPcap(os.Stdin, pcapPort); err != nil {
+				return err
+			}
+		}
+		for _, path := range pcaps {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			r, err := readPcap(f, pcapPort)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %q", path, err)
+			}
+			requests = append(requests, r...)
+			header.Sources = append(header.Sources, filepath.Base(path))
+		}
+
+		entries, skipped := capturedEntries(requests, urlArg, anonymizer)
+		if skipped > 0 {
+			fmt.Fprintf(os.Stderr, "%d requests could not be anonymized and were skipped.\n", skipped)
+		}
+		return writeTimestamped(entries, header)
+	},
+}
+
+// capturedEntries returns the entries of the captured requests, timestamped, and the number of requests skipped
+// because their bodies could not be anonymized.
+func capturedEntries(requests []*capturedRequest, urlArg string, anonymizer *anon.Anonymizer) (loadspec.ByDelaySinceLastNanos, int) {
+	var entries loadspec.ByDelaySinceLastNanos
+	skipped := 0
+	for _, r := range requests {
+		entry := loadspec.Entry{}
+		if r.method != "GET" {
+			entry.Method = r.method
+		}
+		if len(r.body) > 0 {
+			src, err := normalizeSource(string(r.body), anonymizer)
+			switch {
+			case err == nil:
+				entry.Source = src
+			case anonymizer == nil:
+				// Not a JSON object (i.e. msearch), kept as is.
+				entry.Source = string(r.body)
+			default:
+				// Can not be anonymized, not recorded to avoid leaking data.
+				skipped++
+				continue
+			}
+		}
+		// Keeping timestamp here for post-processing.
+		entry.DelaySinceLastNanos = r.ts.UnixNano()
+		host := urlArg
+		if host == "" {
+			host = "http://" + r.host
+		}
+		// Indexes are overridden following the entries, skipped requests do not count.
+		entry.URL = host + overridePathIndex(r.uri, len(entries))
+		entries = append(entries, &entry)
+	}
+	return entries, skipped
+}
+
+// capturedRequest is a HTTP request reassembled from a packet capture.
+type capturedRequest struct {
+	ts     time.Time
+	method string
+	host   string
+	uri    string
+	body   []byte
+}
+
+// Pcap file format: https://wiki.wireshark.org/Development/LibpcapFileFormat
+const (
+	pcapMagicMicros = 0xa1b2c3d4
+	pcapMagicNanos  = 0xa1b23c4d
+	pcapngMagic     = 0x0a0d0d0a
+
+	// Link types: http://www.tcpdump.org/linktypes.html
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+	linkTypeLinuxSLL = 113
+
+	// Largest packet accepted when the capture has no (or a bogus) snapshot length, as tcpdump and wireshark.
+	maxPacketSize = 262144
+)
+
+// readPcap reads the packet capture, returning the HTTP requests sent to port, in capture order.
+func readPcap(r io.Reader, port int) ([]*capturedRequest, error) {
+	br := bufio.NewReader(r)
+	fileHeader := make([]byte, 24)
+	if _, err := io.ReadFull(br, fileHeader); err != nil {
+		return nil, fmt.Errorf("error reading pcap header: %q", err)
+	}
+	var order binary.ByteOrder
+	var tsUnit time.Duration
+	switch magic := binary.LittleEndian.Uint32(fileHeader); {
+	case magic == pcapMagicMicros:
+		order, tsUnit = binary.LittleEndian, time.Microsecond
+	case magic == pcapMagicNanos:
+		order, tsUnit = binary.LittleEndian, time.Nanosecond
+	case binary.BigEndian.Uint32(fileHeader) == pcapMagicMicros:
+		order, tsUnit = binary.BigEndian, time.Microsecond
+	case binary.BigEndian.Uint32(fileHeader) == pcapMagicNanos:
+		order, tsUnit = binary.BigEndian, time.Nanosecond
+	case magic == pcapngMagic:
+		return nil, fmt.Errorf("pcapng is not supported, please convert it using editcap -F pcap")
+	default:
+		return nil, fmt.Errorf("invalid pcap magic number: %x", fileHeader[:4])
+	}
+	linkType := order.Uint32(fileHeader[20:])
+	switch linkType {
+	case linkTypeNull, linkTypeEthernet, linkTypeRaw, linkTypeLinuxSLL:
+	default:
+		return nil, fmt.Errorf("unsupported link type: %d", linkType)
+	}
+
+	snaplen := order.Uint32(fileHeader[16:])
+	if snaplen == 0 || snaplen > maxPacketSize {
+		snaplen = maxPacketSize
+	}
+
+	a := newAssembler(port)
+	recordHeader := make([]byte, 16)
+	for count := 1; ; count++ {
+		if _, err := io.ReadFull(br, recordHeader); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("error reading packet header: %q", err)
+		}
+		ts := time.Unix(int64(order.Uint32(recordHeader)), int64(order.Uint32(recordHeader[4:]))*int64(tsUnit))
+		inclLen := order.Uint32(recordHeader[8:])
+		if inclLen > snaplen {
+			return nil, fmt.Errorf("packet %d: length %d exceeds the snapshot length %d", count, inclLen, snaplen)
+		}
+		data := make([]byte, inclLen)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return nil, fmt.Errorf("error reading packet: %q", err)
+		}
+		if seg := decodePacket(linkType, data); seg != nil {
+			a.add(ts, seg)
+		}
+	}
+	return a.requests, nil
+}
+
+// tcpSegment is the part of a TCP/IP packet which matters to reassemble requests.
+type tcpSegment struct {
+	src, dst         net.IP
+	srcPort, dstPort uint16
+	seq              uint32
+	syn, fin, rst    bool
+	payload          []byte
+}
+
+// decodePacket decodes the link, network and transport layers of the packet. Returns nil if it is not a TCP
+// packet or it can not be decoded (i.e. truncated by snaplen).
+func decodePacket(linkType uint32, data []byte) *tcpSegment {
+	var ipVersion byte
+	switch linkType {
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return nil
+		}
+		etherType := binary.BigEndian.Uint16(data[12:])
+		data = data[14:]
+		// 802.1Q VLAN tags.
+		for etherType == 0x8100 && len(data) >= 4 {
+			etherType = binary.BigEndian.Uint16(data[2:])
+			data = data[4:]
+		}
+		switch etherType {
+		case 0x0800:
+			ipVersion = 4
+		case 0x86dd:
+			ipVersion = 6
+		}
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			return nil
+		}
+		switch binary.BigEndian.Uint16(data[14:]) {
+		case 0x0800:
+			ipVersion = 4
+		case 0x86dd:
+			ipVersion = 6
+		}
+		data = data[16:]
+	case linkTypeNull:
+		// Address family in the capturing host byte order. IPv6 family differs across BSDs.
+		if len(data) < 4 {
+			return nil
+		}
+		family := binary.LittleEndian.Uint32(data)
+		if family > 0xffff {
+			family = binary.BigEndian.Uint32(data)
+		}
+		switch family {
+		case 2:
+			ipVersion = 4
+		case 24, 28, 30:
+			ipVersion = 6
+		}
+		data = data[4:]
+	case linkTypeRaw:
+		if len(data) > 0 {
+			ipVersion = data[0] >> 4
+		}
+	}
+
+	seg := &tcpSegment{}
+	switch ipVersion {
+	case 4:
+		if len(data) < 20 {
+			return nil
+		}
+		ihl := int(data[0]&0x0f) * 4
+		totalLen := int(binary.BigEndian.Uint16(data[2:]))
+		flagsAndOffset := binary.BigEndian.Uint16(data[6:])
+		// Fragments are not supported. TCP rarely gets fragmented.
+		if data[9] != 6 || flagsAndOffset&0x3fff != 0 || ihl < 20 || totalLen < ihl || len(data) < totalLen {
+			return nil
+		}
+		seg.src, seg.dst = net.IP(data[12:16]), net.IP(data[16:20])
+		data = data[ihl:totalLen]
+	case 6:
+		if len(data) < 40 {
+			return nil
+		}
+		payloadLen := int(binary.BigEndian.Uint16(data[4:]))
+		// Extension headers are not supported.
+		if data[6] != 6 || len(data) < 40+payloadLen {
+			return nil
+		}
+		seg.src, seg.dst = net.IP(data[8:24]), net.IP(data[24:40])
+		data = data[40 : 40+payloadLen]
+	default:
+		return nil
+	}
+
+	if len(data) < 20 {
+		return nil
+	}
+	dataOffset := int(data[12]>>4) * 4
+	if dataOffset < 20 || len(data) < dataOffset {
+		return nil
+	}
+	seg.srcPort = binary.BigEndian.Uint16(data)
+	seg.dstPort = binary.BigEndian.Uint16(data[2:])
+	seg.seq = binary.BigEndian.Uint32(data[4:])
+	flags := data[13]
+	seg.fin, seg.syn, seg.rst = flags&0x01 != 0, flags&0x02 != 0, flags&0x04 != 0
+	seg.payload = data[dataOffset:]
+	return seg
+}
+
+// tcpFlow keeps the reassembly state of one direction of a TCP connection.
+type tcpFlow struct {
+	started bool
+	nextSeq uint32
+	// Out of order segments, by sequence number.
+	pending map[uint32][]byte
+	buf     []byte
+	// Timestamp of the packet which carried the first byte of buf.
+	bufTS time.Time
+	host  string
+}
+
+// assembler reassembles HTTP requests from client to server TCP segments.
+type assembler struct {
+	port     uint16
+	flows    map[string]*tcpFlow
+	requests []*capturedRequest
+}
+
+func newAssembler(port int) *assembler {
+	return &assembler{port: uint16(port), flows: make(map[string]*tcpFlow)}
+}
+
+func (a *assembler) add(ts time.Time, seg *tcpSegment) {
+	if seg.dstPort != a.port {
+		return
+	}
+	key := fmt.Sprintf("%s:%d->%s:%d", seg.src, seg.srcPort, seg.dst, seg.dstPort)
+	flow, ok := a.flows[key]
+	if !ok || seg.syn {
+		flow = &tcpFlow{
+			pending: make(map[uint32][]byte),
+			host:    net.JoinHostPort(seg.dst.String(), fmt.Sprint(seg.dstPort)),
+		}
+		a.flows[key] = flow
+	}
+	switch {
+	case seg.syn:
+		flow.started = true
+		flow.nextSeq = seg.seq + 1
+	case !flow.started && len(seg.payload) > 0:
+		// Capture started after the connection was established.
+		flow.started = true
+		flow.nextSeq = seg.seq
+	}
+	if flow.started && len(seg.payload) > 0 {
+		flow.pending[seg.seq] = seg.payload
+		for a.reassemble(ts, flow) {
+		}
+		a.parseRequests(ts, flow)
+	}
+	if seg.fin || seg.rst {
+		delete(a.flows, key)
+	}
+}
+
+// reassemble appends the next in order pending segment to the flow buffer, returning false if there is none.
+func (a *assembler) reassemble(ts time.Time, flow *tcpFlow) bool {
+	for seq, payload := range flow.pending {
+		// Sequence numbers wrap around.
+		diff := int32(flow.nextSeq - seq)
+		if diff < 0 {
+			continue
+		}
+		delete(flow.pending, seq)
+		if int(diff) >= len(payload) {
+			// Retransmission.
+			continue
+		}
+		if len(flow.buf) == 0 {
+			flow.bufTS = ts
+		}
+		flow.buf = append(flow.buf, payload[diff:]...)
+		flow.nextSeq += uint32(len(payload)) - uint32(diff)
+		return true
+	}
+	return false
+}
+
+// parseRequests parses all complete requests in the flow buffer.
+func (a *assembler) parseRequests(ts time.Time, flow *tcpFlow) {
+	for len(flow.buf) > 0 {
+		// Partial header lines might look malformed, waiting for the whole header.
+		if !bytes.Contains(flow.buf, []byte("\r\n\r\n")) {
+			return
+		}
+		r := bytes.NewReader(flow.buf)
+		br := bufio.NewReader(r)
+		req, err := http.ReadRequest(br)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return
+		}
+		if err != nil {
+			// Not HTTP or the capture missed part of the stream. Discarding until the next request.
+			flow.buf = nil
+			return
+		}
+		body, err := ioutil.ReadAll(req.Body)
+		if err == io.ErrUnexpectedEOF {
+			return
+		}
+		if err != nil {
+			flow.buf = nil
+			return
+		}
+		host := req.Host
+		if host == "" {
+			host = flow.host
+		}
+		a.requests = append(a.requests, &capturedRequest{
+			ts:     flow.bufTS,
+			method: req.Method,
+			host:   host,
+			uri:    req.RequestURI,
+			body:   body,
+		})
+		consumed := len(flow.buf) - r.Len() - br.Buffered()
+		flow.buf = flow.buf[consumed:]
+		// Pipelined requests get the timestamp of the current packet.
+		flow.bufTS = ts
+	}
+}
@@ -0,0 +1,102 @@
+package loadspec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielfireman/esperf/anon"
+	"github.com/matryer/is"
+)
+
+func TestReadPcap_KeepAlive(t *testing.T) {
+	is := is.New(t)
+	// Ethernet/IPv4 capture, microsecond precision. A POST whose headers are retransmitted and whose body
+	// arrives out of order, followed by a GET in the same connection. Traffic to port 80 is ignored.
+	f, err := os.Open("testdata/keepalive.pcap")
+	is.NoErr(err)
+	defer f.Close()
+	requests, err := readPcap(f, 9200)
+	is.NoErr(err)
+	is.Equal(len(requests), 2)
+
+	is.Equal(requests[0].method, "POST")
+	is.Equal(requests[0].host, "es:9200")
+	is.Equal(requests[0].uri, "/wiki/_search")
+	is.Equal(string(requests[0].body), `{"size":0,"query":{"match_all":{}}}`)
+	is.Equal(requests[0].ts, time.Unix(1000, 500000000))
+
+	is.Equal(requests[1].method, "GET")
+	is.Equal(requests[1].uri, "/wiki/_count?q=foo")
+	is.Equal(len(requests[1].body), 0)
+	is.Equal(requests[1].ts, time.Unix(1001, 250000000))
+}
+
+func TestReadPcap_IPv6Nanos(t *testing.T) {
+	is := is.New(t)
+	// Big-endian Linux cooked IPv6 capture, nanosecond precision. It starts after the connection was
+	// established, sequence numbers wrap around and has a chunked request followed by pipelined ones.
+	f, err := os.Open("testdata/ipv6_sll_nanos.pcap")
+	is.NoErr(err)
+	defer f.Close()
+	requests, err := readPcap(f, 9200)
+	is.NoErr(err)
+	is.Equal(len(requests), 3)
+
+	is.Equal(requests[0].method, "POST")
+	is.Equal(requests[0].host, "[2001:db8::2]:9200")
+	is.Equal(requests[0].uri, "/_msearch")
+	is.Equal(string(requests[0].body), "{}\n{\"size\":0}\n\n")
+	is.Equal(requests[0].ts, time.Unix(2000, 123))
+
+	is.Equal(requests[1].uri, "/a/_search")
+	is.Equal(requests[1].ts, time.Unix(2000, 500000000))
+	is.Equal(requests[2].uri, "/b/_search")
+	is.Equal(requests[2].ts, time.Unix(2000, 500000000))
+}
+
+func TestReadPcap_Invalid(t *testing.T) {
+	is := is.New(t)
+	f, err := os.Open("testdata/keepalive.pcap")
+	is.NoErr(err)
+	defer f.Close()
+	requests, err := readPcap(f, 9300)
+	is.NoErr(err)
+	is.Equal(len(requests), 0)
+
+	_, err = readPcap(strings.NewReader("not a pcap file at all!!"), 9200)
+	is.True(err != nil)
+}
+
+func TestReadPcap_PacketLength(t *testing.T) {
+	is := is.New(t)
+	capture, err := ioutil.ReadFile("testdata/keepalive.pcap")
+	is.NoErr(err)
+	// Little-endian capture, the first packet claims to be 4GiB long.
+	binary.LittleEndian.PutUint32(capture[24+8:], 0xffffffff)
+	_, err = readPcap(bytes.NewReader(capture), 9200)
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "exceeds the snapshot length"))
+}
+
+func TestCapturedEntries(t *testing.T) {
+	is := is.New(t)
+	indexOverride = []string{"a", "b"}
+	defer func() { indexOverride = []string{} }()
+	requests := []*capturedRequest{
+		{method: "POST", host: "es:9200", uri: "/wiki/_search", body: []byte(`{"query":{"match_all":{}}}`)},
+		{method: "POST", host: "es:9200", uri: "/wiki/_search", body: []byte("not json")},
+		{method: "GET", host: "es:9200", uri: "/wiki/_search"},
+	}
+	anonymizer := &anon.Anonymizer{FMap: anon.FieldsMap{}, FRE: anon.MustParseFieldsRE([]string{"name::(.*)"})}
+	entries, skipped := capturedEntries(requests, "", anonymizer)
+	is.Equal(skipped, 1)
+	is.Equal(len(entries), 2)
+	// Skipped requests do not shift the overridden indexes.
+	is.Equal(entries[0].URL, "http://es:9200/a/_search")
+	is.Equal(entries[1].URL, "http://es:9200/b/_search")
+}
@@ -1,9 +1,13 @@
 package loadspec
 
 import (
+	"io"
+	"io/ioutil"
 	"math/rand"
+	"os"
 	"time"
 
+	"github.com/danielfireman/esperf/loadspec"
 	"github.com/spf13/cobra"
 )
 
@@ -12,18 +16,54 @@ const (
 )
 
 var (
-	randGen = rand.New(rand.NewSource(time.Now().UnixNano()))
+	seed         int64
+	randGen      *rand.Rand
+	outputFormat loadspec.Format
+	formatName   string
 )
 
 var RootCmd = &cobra.Command{
 	Use:   "loadspec",
 	Short: "Generates loadspecs for esperf",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		randGen = rand.New(rand.NewSource(seed))
+		var err error
+		outputFormat, err = loadspec.ParseFormat(formatName)
+		return err
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		cmd.HelpFunc()(cmd, args)
 	},
 }
 
 func init() {
+	RootCmd.PersistentFlags().Int64Var(&seed, "seed", 0, "Seed of the random number generator. Zero means a time-based seed. The seed is recorded in the loadspec header.")
+	RootCmd.PersistentFlags().StringVar(&formatName, "output_format", loadspec.DefaultFormat.String(), "Format of the generated loadspec: json or bin (compact binary), optionally compressed by appending .gz or .zst (i.e. json.gz). Input format is always detected automatically.")
 	RootCmd.AddCommand(parseSlowlogCmd)
+	RootCmd.AddCommand(parseAccessLogCmd)
+	RootCmd.AddCommand(parsePcapCmd)
 	RootCmd.AddCommand(genLoadspec)
+	RootCmd.AddCommand(statsCmd)
+	RootCmd.AddCommand(sliceCmd)
+	RootCmd.AddCommand(shiftCmd)
+	RootCmd.AddCommand(sampleCmd)
+	RootCmd.AddCommand(filterCmd)
+	RootCmd.AddCommand(concatCmd)
+	RootCmd.AddCommand(interleaveCmd)
+	RootCmd.AddCommand(validateCmd)
+	RootCmd.AddCommand(convertCmd)
+	RootCmd.AddCommand(importCmd)
+	RootCmd.AddCommand(exportCmd)
+}
+
+// openInput opens the file passed in as first argument. Falls back to STDIN if there are no arguments
+// or the argument is "-".
+func openInput(args []string) (io.ReadCloser, error) {
+	if len(args) == 0 || args[0] == "-" {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	return os.Open(args[0])
 }
@@ -0,0 +1,293 @@
+package loadspec
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/danielfireman/esperf/loadspec"
+	"github.com/spf13/cobra"
+)
+
+var statsJSON bool
+
+func init() {
+	statsCmd.Flags().BoolVar(&statsJSON, "json", false, "Outputs statistics as JSON instead of human-readable text.")
+}
+
+var statsCmd = &cobra.Command{
+	Use:   "stats [loadspec]",
+	Short: "Describes an existing loadspec.",
+	Long:  "Describes an existing loadspec (duration, arrival rate, inter-arrival distribution, URLs and body sizes). Reads from STDIN if no file is passed in.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		in, err := openInput(args)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		_, entries, err := loadspec.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		s, err := computeStats(entries)
+		if err != nil {
+			return err
+		}
+
+		writer := bufio.NewWriter(os.Stdout)
+		defer writer.Flush()
+		if statsJSON {
+			enc := json.NewEncoder(writer)
+			enc.SetIndent("", "  ")
+			return enc.Encode(s)
+		}
+		return s.writeText(writer)
+	},
+}
+
+// Inter-arrival histogram bucket upper bounds. Last bucket catches everything else.
+var interArrivalBuckets = []time.Duration{
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+type histogramBucket struct {
+	// Zero means no upper bound.
+	UpperBoundNanos int64 `json:"upper_bound_nanos"`
+	Count           int   `json:"count"`
+}
+
+type interArrivalStats struct {
+	MeanNanos   int64   `json:"mean_nanos"`
+	StdDevNanos int64   `json:"stddev_nanos"`
+	MinNanos    int64   `json:"min_nanos"`
+	MaxNanos    int64   `json:"max_nanos"`
+	CV          float64 `json:"coefficient_of_variation"`
+	// Kolmogorov–Smirnov distance between the observed inter-arrival times and an exponential
+	// distribution with the same mean.
+	KSDistance float64 `json:"ks_distance"`
+	// Variance to mean ratio of the number of arrivals per second. Poisson processes are close to 1.
+	DispersionIndex float64           `json:"dispersion_index"`
+	Distribution    string            `json:"distribution"`
+	Histogram       []histogramBucket `json:"histogram"`
+}
+
+type sizeStats struct {
+	Min int `json:"min"`
+	P50 int `json:"p50"`
+	P90 int `json:"p90"`
+	P99 int `json:"p99"`
+	Max int `json:"max"`
+}
+
+type specStats struct {
+	Entries         int               `json:"entries"`
+	DurationNanos   int64             `json:"duration_nanos"`
+	MeanQPS         float64           `json:"mean_qps"`
+	PeakQPS         int               `json:"peak_qps"`
+	InterArrival    interArrivalStats `json:"inter_arrival"`
+	DistinctURLs    int               `json:"distinct_urls"`
+	DistinctIndices int               `json:"distinct_indices"`
+	DistinctHosts   int               `json:"distinct_hosts"`
+	BodySize        sizeStats         `json:"body_size_bytes"`
+}
+
+func computeStats(entries []loadspec.Entry) (*specStats, error) {
+	s := &specStats{Entries: len(entries)}
+	if len(entries) == 0 {
+		return s, nil
+	}
+	urls := make(map[string]struct{})
+	indices := make(map[string]struct{})
+	hosts := make(map[string]struct{})
+	perSecond := make(map[int64]int)
+	bodySizes := make([]int, len(entries))
+	// The first entry delay is the time before the first request, not an inter-arrival time.
+	interArrivals := make([]float64, 0, len(entries)-1)
+	var elapsed int64
+	for i, e := range entries {
+		elapsed += e.DelaySinceLastNanos
+		perSecond[elapsed/int64(time.Second)]++
+		if i > 0 {
+			interArrivals = append(interArrivals, float64(e.DelaySinceLastNanos))
+		}
+		bodySizes[i] = len(e.Source)
+
+		urls[e.URL] = struct{}{}
+		u, err := url.Parse(e.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid url at entry %d: %q", e.ID, err)
+		}
+		hosts[u.Host] = struct{}{}
+		for _, index := range indicesFromPath(u.Path) {
+			indices[index] = struct{}{}
+		}
+	}
+	s.DurationNanos = elapsed
+	s.DistinctURLs = len(urls)
+	s.DistinctIndices = len(indices)
+	s.DistinctHosts = len(hosts)
+	if elapsed > 0 {
+		s.MeanQPS = float64(len(entries)) / time.Duration(elapsed).Seconds()
+	}
+	for _, c := range perSecond {
+		if c > s.PeakQPS {
+			s.PeakQPS = c
+		}
+	}
+	s.InterArrival = computeInterArrivalStats(interArrivals, perSecond, elapsed)
+
+	sort.Ints(bodySizes)
+	s.BodySize = sizeStats{
+		Min: bodySizes[0],
+		P50: bodySizes[percentileIndex(len(bodySizes), 0.5)],
+		P90: bodySizes[percentileIndex(len(bodySizes), 0.9)],
+		P99: bodySizes[percentileIndex(len(bodySizes), 0.99)],
+		Max: bodySizes[len(bodySizes)-1],
+	}
+	return s, nil
+}
+
+func computeInterArrivalStats(ia []float64, perSecond map[int64]int, elapsed int64) interArrivalStats {
+	s := interArrivalStats{Histogram: make([]histogramBucket, len(interArrivalBuckets)+1)}
+	for i, b := range interArrivalBuckets {
+		s.Histogram[i].UpperBoundNanos = b.Nanoseconds()
+	}
+	if len(ia) == 0 {
+		s.Distribution = "unknown"
+		return s
+	}
+	sort.Float64s(ia)
+	var sum float64
+	for _, v := range ia {
+		sum += v
+		b := sort.Search(len(interArrivalBuckets), func(i int) bool { return v < float64(interArrivalBuckets[i]) })
+		s.Histogram[b].Count++
+	}
+	mean := sum / float64(len(ia))
+	var sqDiff float64
+	for _, v := range ia {
+		sqDiff += (v - mean) * (v - mean)
+	}
+	stdDev := math.Sqrt(sqDiff / float64(len(ia)))
+	s.MeanNanos = int64(mean)
+	s.StdDevNanos = int64(stdDev)
+	s.MinNanos = int64(ia[0])
+	s.MaxNanos = int64(ia[len(ia)-1])
+	if mean > 0 {
+		s.CV = stdDev / mean
+		s.KSDistance = ksExponential(ia, mean)
+	}
+
+	// Dispersion of arrivals per second, considering seconds without arrivals.
+	numSeconds := elapsed/int64(time.Second) + 1
+	var countSum, countSqSum float64
+	for _, c := range perSecond {
+		countSum += float64(c)
+		countSqSum += float64(c) * float64(c)
+	}
+	countMean := countSum / float64(numSeconds)
+	if countMean > 0 {
+		s.DispersionIndex = (countSqSum/float64(numSeconds) - countMean*countMean) / countMean
+	}
+	s.Distribution = classifyDistribution(s.CV, s.KSDistance, len(ia))
+	return s
+}
+
+// ksExponential returns the Kolmogorov–Smirnov distance between the sorted sample and the exponential
+// distribution with the passed-in mean.
+func ksExponential(sorted []float64, mean float64) float64 {
+	n := float64(len(sorted))
+	var d float64
+	for i, v := range sorted {
+		cdf := 1 - math.Exp(-v/mean)
+		d = math.Max(d, math.Max(float64(i+1)/n-cdf, cdf-float64(i)/n))
+	}
+	return d
+}
+
+func classifyDistribution(cv, ks float64, n int) string {
+	switch {
+	case cv < 0.1:
+		return "constant"
+	// Critical value of the KS test with 5% significance level.
+	case ks < 1.36/math.Sqrt(float64(n)):
+		return "poisson"
+	case cv > 1:
+		return "bursty"
+	default:
+		return "regular"
+	}
+}
+
+// indicesFromPath extracts the indices from an elasticsearch URL path, for instance
+// /index1,index2/_search returns [index1, index2].
+func indicesFromPath(path string) []string {
+	p := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+	if p[0] == "" || strings.HasPrefix(p[0], "_") {
+		return nil
+	}
+	return strings.Split(p[0], ",")
+}
+
+func percentileIndex(n int, p float64) int {
+	i := int(math.Ceil(p*float64(n))) - 1
+	if i < 0 {
+		return 0
+	}
+	return i
+}
+
+func (s *specStats) writeText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	ia := s.InterArrival
+	fmt.Fprintf(tw, "Entries:\t%d\n", s.Entries)
+	fmt.Fprintf(tw, "Duration:\t%v\n", time.Duration(s.DurationNanos))
+	fmt.Fprintf(tw, "Mean QPS:\t%.2f\n", s.MeanQPS)
+	fmt.Fprintf(tw, "Peak QPS:\t%d\n", s.PeakQPS)
+	fmt.Fprintf(tw, "Distinct URLs:\t%d\n", s.DistinctURLs)
+	fmt.Fprintf(tw, "Distinct indices:\t%d\n", s.DistinctIndices)
+	fmt.Fprintf(tw, "Distinct hosts:\t%d\n", s.DistinctHosts)
+	fmt.Fprintf(tw, "Body size (bytes):\tmin:%d p50:%d p90:%d p99:%d max:%d\n", s.BodySize.Min, s.BodySize.P50, s.BodySize.P90, s.BodySize.P99, s.BodySize.Max)
+	fmt.Fprintf(tw, "Inter-arrival:\tmean:%v stddev:%v min:%v max:%v\n", time.Duration(ia.MeanNanos), time.Duration(ia.StdDevNanos), time.Duration(ia.MinNanos), time.Duration(ia.MaxNanos))
+	fmt.Fprintf(tw, "Distribution:\t%s (cv:%.2f ks:%.3f dispersion:%.2f)\n", ia.Distribution, ia.CV, ia.KSDistance, ia.DispersionIndex)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "Inter-arrival histogram:")
+	max := 0
+	for _, b := range ia.Histogram {
+		if b.Count > max {
+			max = b.Count
+		}
+	}
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	for i, b := range ia.Histogram {
+		var label string
+		if b.UpperBoundNanos > 0 {
+			label = fmt.Sprintf("< %v", time.Duration(b.UpperBoundNanos))
+		} else {
+			label = fmt.Sprintf(">= %v", time.Duration(ia.Histogram[i-1].UpperBoundNanos))
+		}
+		bar := ""
+		if max > 0 {
+			bar = strings.Repeat("#", b.Count*50/max)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t %s\n", label, b.Count, bar)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,47 @@
+package loadspec
+
+import (
+	"testing"
+	"time"
+
+	"github.com/danielfireman/esperf/loadspec"
+	"github.com/matryer/is"
+)
+
+func TestComputeStats(t *testing.T) {
+	is := is.New(t)
+	entries := []loadspec.Entry{
+		{DelaySinceLastNanos: 0, URL: "http://host01:9200/index01/_search", Source: "{}"},
+		{DelaySinceLastNanos: int64(500 * time.Millisecond), URL: "http://host01:9200/index01,index02/_search", Source: "{\"size\":1}"},
+		{DelaySinceLastNanos: int64(500 * time.Millisecond), URL: "http://host02:9200/_search", Source: "{}"},
+		{DelaySinceLastNanos: int64(500 * time.Millisecond), URL: "http://host01:9200/index01/_search", Source: "{}"},
+	}
+	s, err := computeStats(entries)
+	is.NoErr(err)
+	is.Equal(s.Entries, 4)
+	is.Equal(s.DurationNanos, int64(1500*time.Millisecond))
+	is.Equal(s.PeakQPS, 2)
+	is.Equal(s.DistinctURLs, 3)
+	is.Equal(s.DistinctIndices, 2)
+	is.Equal(s.DistinctHosts, 2)
+	is.Equal(s.BodySize.Min, 2)
+	is.Equal(s.BodySize.Max, 10)
+	is.Equal(s.InterArrival.MeanNanos, int64(500*time.Millisecond))
+	is.Equal(s.InterArrival.Distribution, "constant")
+	is.Equal(s.InterArrival.Histogram[5].Count, 3) // [100ms, 1s)
+}
+
+func TestComputeStats_Empty(t *testing.T) {
+	is := is.New(t)
+	s, err := computeStats(nil)
+	is.NoErr(err)
+	is.Equal(s.Entries, 0)
+}
+
+func TestIndicesFromPath(t *testing.T) {
+	is := is.New(t)
+	is.Equal(indicesFromPath("/index01/_search"), []string{"index01"})
+	is.Equal(indicesFromPath("/index01,index02/type/_search"), []string{"index01", "index02"})
+	is.Equal(len(indicesFromPath("/_search")), 0)
+	is.Equal(len(indicesFromPath("")), 0)
+}
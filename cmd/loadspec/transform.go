@@ -0,0 +1,181 @@
+package loadspec
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/danielfireman/esperf/internal/cmdutil"
+	"github.com/danielfireman/esperf/loadspec"
+	"github.com/spf13/cobra"
+)
+
+var (
+	sliceFrom, sliceTo time.Duration
+	shiftBy            time.Duration
+	sampleRatio        float64
+	filterURL          string
+	filterSource       string
+	filterInvert       bool
+	concatGap          time.Duration
+)
+
+func init() {
+	sliceCmd.Flags().DurationVar(&sliceFrom, "from", time.Duration(0), "Beginning of the time window (inclusive), relative to the beginning of the loadspec.")
+	sliceCmd.Flags().DurationVar(&sliceTo, "to", time.Duration(0), "End of the time window (exclusive), relative to the beginning of the loadspec. Zero means until the end.")
+	shiftCmd.Flags().DurationVar(&shiftBy, "by", time.Duration(0), "Amount of time to shift the loadspec. Negative values discard entries arriving before the beginning of the test.")
+	sampleCmd.Flags().Float64Var(&sampleRatio, "ratio", 1, "Probability of keeping each entry, in the [0, 1] interval.")
+	filterCmd.Flags().StringVar(&filterURL, "url", "", "Regular expression that entries URL must match.")
+	filterCmd.Flags().StringVar(&filterSource, "source", "", "Regular expression that entries source must match.")
+	filterCmd.Flags().BoolVar(&filterInvert, "invert", false, "Keep entries which do not match instead.")
+	concatCmd.Flags().DurationVar(&concatGap, "gap", time.Duration(0), "Time to wait between the last entry of a loadspec and the first entry of the next one.")
+}
+
+// transform reads a single loadspec (file argument or STDIN), applies f and writes the result to STDOUT.
+func transform(cmd *cobra.Command, args []string, f func([]loadspec.Entry) ([]loadspec.Entry, error)) error {
+	in, err := openInput(args)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	parent, entries, err := loadspec.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	entries, err = f(entries)
+	if err != nil {
+		return err
+	}
+	header := cmdutil.NewHeader(cmd, args)
+	header.Seed = seed
+	header.Sources = args
+	if parent != nil {
+		header.Parents = []*loadspec.Header{parent}
+	}
+	return loadspec.WriteAll(os.Stdout, outputFormat, header, entries)
+}
+
+// readSpecs reads all loadspecs passed in as arguments. It returns the header which describes the resulting
+// loadspec and the entries of each loadspec read.
+func readSpecs(cmd *cobra.Command, args []string) (*loadspec.Header, [][]loadspec.Entry, error) {
+	header := cmdutil.NewHeader(cmd, args)
+	header.Sources = args
+	var specs [][]loadspec.Entry
+	for _, path := range args {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		parent, entries, err := loadspec.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading %s: %q", path, err)
+		}
+		if parent != nil {
+			header.Parents = append(header.Parents, parent)
+		}
+		specs = append(specs, entries)
+	}
+	return header, specs, nil
+}
+
+var sliceCmd = &cobra.Command{
+	Use:   "slice [loadspec]",
+	Short: "Outputs the entries of the loadspec which arrive within a time window.",
+	Long:  "Outputs the entries of the loadspec which arrive within the [--from, --to) time window. The resulting loadspec starts at --from. Reads from STDIN if no file is passed in.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if sliceFrom < 0 || (sliceTo > 0 && sliceTo <= sliceFrom) {
+			return fmt.Errorf("invalid time window: [%v, %v)", sliceFrom, sliceTo)
+		}
+		return transform(cmd, args, func(entries []loadspec.Entry) ([]loadspec.Entry, error) {
+			return loadspec.Slice(entries, sliceFrom, sliceTo), nil
+		})
+	},
+}
+
+var shiftCmd = &cobra.Command{
+	Use:   "shift [loadspec]",
+	Short: "Shifts all entries of the loadspec in time.",
+	Long:  "Shifts all entries of the loadspec in time. Reads from STDIN if no file is passed in.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return transform(cmd, args, func(entries []loadspec.Entry) ([]loadspec.Entry, error) {
+			return loadspec.Shift(entries, shiftBy), nil
+		})
+	},
+}
+
+var sampleCmd = &cobra.Command{
+	Use:   "sample [loadspec]",
+	Short: "Outputs a random sample of the loadspec entries.",
+	Long:  "Outputs a random sample of the loadspec entries, preserving their arrival times. Reads from STDIN if no file is passed in.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if sampleRatio < 0 || sampleRatio > 1 {
+			return fmt.Errorf("ratio must be within [0, 1], got:%f", sampleRatio)
+		}
+		return transform(cmd, args, func(entries []loadspec.Entry) ([]loadspec.Entry, error) {
+			return loadspec.Sample(entries, sampleRatio, randGen), nil
+		})
+	},
+}
+
+var filterCmd = &cobra.Command{
+	Use:   "filter [loadspec]",
+	Short: "Outputs the loadspec entries matching the passed-in regular expressions.",
+	Long:  "Outputs the loadspec entries which URL and source match the passed-in regular expressions, preserving their arrival times. Reads from STDIN if no file is passed in.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if filterURL == "" && filterSource == "" {
+			return fmt.Errorf("please set --url or --source")
+		}
+		var urlRE, sourceRE *regexp.Regexp
+		var err error
+		if filterURL != "" {
+			if urlRE, err = regexp.Compile(filterURL); err != nil {
+				return err
+			}
+		}
+		if filterSource != "" {
+			if sourceRE, err = regexp.Compile(filterSource); err != nil {
+				return err
+			}
+		}
+		return transform(cmd, args, func(entries []loadspec.Entry) ([]loadspec.Entry, error) {
+			return loadspec.Filter(entries, func(e *loadspec.Entry) bool {
+				match := (urlRE == nil || urlRE.MatchString(e.URL)) && (sourceRE == nil || sourceRE.MatchString(e.Source))
+				return match != filterInvert
+			}), nil
+		})
+	},
+}
+
+var concatCmd = &cobra.Command{
+	Use:   "concat loadspec loadspec...",
+	Short: "Outputs a loadspec which replays the passed-in loadspecs one after another.",
+	Long:  "Outputs a loadspec which replays the passed-in loadspecs one after another.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 2 {
+			return fmt.Errorf("please pass in at least two loadspecs")
+		}
+		header, specs, err := readSpecs(cmd, args)
+		if err != nil {
+			return err
+		}
+		return loadspec.WriteAll(os.Stdout, outputFormat, header, loadspec.Concat(concatGap, specs...))
+	},
+}
+
+var interleaveCmd = &cobra.Command{
+	Use:   "interleave loadspec loadspec...",
+	Short: "Outputs a loadspec which superimposes the passed-in loadspecs.",
+	Long:  "Outputs a loadspec which superimposes the passed-in loadspecs, merging their arrival streams. Use shift to offset the loadspecs before interleaving them.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 2 {
+			return fmt.Errorf("please pass in at least two loadspecs")
+		}
+		header, specs, err := readSpecs(cmd, args)
+		if err != nil {
+			return err
+		}
+		return loadspec.WriteAll(os.Stdout, outputFormat, header, loadspec.Interleave(specs...))
+	},
+}
@@ -0,0 +1,317 @@
+package loadspec
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/danielfireman/esperf/internal/esclient"
+	"github.com/danielfireman/esperf/loadspec"
+	"github.com/spf13/cobra"
+)
+
+var (
+	validateTarget  string
+	validateTimeout time.Duration
+)
+
+func init() {
+	validateCmd.Flags().StringVar(&validateTarget, "target", "", "Elasticsearch URL (i.e. http://localhost:9200). If set, each distinct query is validated against it using the validate API.")
+	validateCmd.Flags().DurationVar(&validateTimeout, "timeout", 30*time.Second, "Timeout to be used in connections to ES.")
+}
+
+var validateCmd = &cobra.Command{
+	Use:   "validate [loadspec]",
+	Short: "Checks whether all loadspec entries are valid.",
+	Long: `Checks whether all loadspec entries are valid: JSON syntax, URL, source, delays and ID uniqueness.
+If --target is set and no other problem is found, each distinct query is also sent to the _validate/query?explain
+endpoint.
+Reads from STDIN if no file is passed in.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		in, err := openInput(args)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		writer := bufio.NewWriter(os.Stdout)
+		defer writer.Flush()
+
+		entries, problems, headerProblems, err := validateEntries(in, writer)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Checked %d entries, %d problems found.\n", len(entries)+problems, problems)
+		if headerProblems > 0 {
+			fmt.Fprintf(os.Stderr, "%d header problems found.\n", headerProblems)
+			problems += headerProblems
+		}
+
+		switch {
+		case validateTarget == "":
+		case problems > 0:
+			// Queries are only sent once the loadspec is known to be well formed.
+			fmt.Fprintf(os.Stderr, "Validation against %s skipped because of %d problems found offline.\n", validateTarget, problems)
+		default:
+			client, err := esclient.New(esclient.Default, esclient.Options{Timeout: validateTimeout})
+			if err != nil {
+				return err
+			}
+			rejected, err := validateQueries(client, validateTarget, entries, writer)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "%d queries rejected by %s.\n", rejected, validateTarget)
+			problems += rejected
+		}
+		if problems > 0 {
+			return fmt.Errorf("invalid loadspec: %d problems found", problems)
+		}
+		return nil
+	},
+}
+
+// validateEntries checks the header and every entry of the loadspec, writing the problems found to out. It
+// returns the successfully decoded entries, the number of invalid entries and the number of header problems.
+func validateEntries(in io.Reader, out io.Writer) ([]loadspec.Entry, int, int, error) {
+	rc, f, err := loadspec.Decompress(in)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer rc.Close()
+	v := &entriesValidator{out: out, ids: make(map[int]string)}
+	if f.Encoding == loadspec.BinaryEncoding {
+		err = v.validateBinary(rc)
+	} else {
+		err = v.validateJSON(rc)
+	}
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return v.entries, v.problems, v.headerProblems, nil
+}
+
+type entriesValidator struct {
+	out     io.Writer
+	ids     map[int]string
+	entries []loadspec.Entry
+	// Invalid entries, including lines which could not be decoded, and problems of the header.
+	problems       int
+	headerProblems int
+}
+
+func (v *entriesValidator) problem(location, format string, a ...interface{}) {
+	fmt.Fprintf(v.out, "%s: %s\n", location, fmt.Sprintf(format, a...))
+	v.problems++
+}
+
+func (v *entriesValidator) headerProblem(location, format string, a ...interface{}) {
+	fmt.Fprintf(v.out, "%s: %s\n", location, fmt.Sprintf(format, a...))
+	v.headerProblems++
+}
+
+func (v *entriesValidator) check(e *loadspec.Entry, location string) {
+	entryProblems := checkEntry(e)
+	if l, ok := v.ids[e.ID]; ok {
+		entryProblems = append(entryProblems, fmt.Sprintf("duplicated id, first seen at %s", l))
+	} else {
+		v.ids[e.ID] = location
+	}
+	for _, p := range entryProblems {
+		fmt.Fprintf(v.out, "%s (id %d): %s\n", location, e.ID, p)
+	}
+	if len(entryProblems) > 0 {
+		v.problems++
+		return
+	}
+	v.entries = append(v.entries, *e)
+}
+
+// validateJSON checks the loadspec line by line, so a malformed line does not prevent the remaining ones
+// from being checked.
+func (v *entriesValidator) validateJSON(in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	first := true
+	for lineno := 1; scanner.Scan(); lineno++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		location := fmt.Sprintf("line %d", lineno)
+		if first {
+			first = false
+			h, err := loadspec.DecodeHeader(scanner.Bytes())
+			if err != nil {
+				v.headerProblem(location, "invalid header: %q", err)
+				continue
+			}
+			if h != nil {
+				v.checkHeader(h, location)
+				continue
+			}
+		}
+		var entry loadspec.Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			v.problem(location, "invalid entry: %q", err)
+			continue
+		}
+		v.check(&entry, location)
+	}
+	return scanner.Err()
+}
+
+// validateBinary checks the binary loadspec entry by entry. Decoding errors can not be recovered from.
+func (v *entriesValidator) validateBinary(in io.Reader) error {
+	reader, err := loadspec.NewReader(in)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if h := reader.Header(); h != nil {
+		v.checkHeader(h, "header")
+	}
+	for i := 1; ; i++ {
+		entry, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		v.check(entry, fmt.Sprintf("entry #%d", i))
+	}
+}
+
+func (v *entriesValidator) checkHeader(h *loadspec.Header, location string) {
+	if h.Version > loadspec.FormatVersion {
+		v.headerProblem(location, "unsupported loadspec version: %d", h.Version)
+	}
+}
+
+// checkEntry returns a description of each problem found in the entry.
+func checkEntry(e *loadspec.Entry) []string {
+	var problems []string
+	if e.DelaySinceLastNanos < 0 {
+		problems = append(problems, fmt.Sprintf("negative delay: %d", e.DelaySinceLastNanos))
+	}
+	if e.TimeoutNanos < 0 {
+		problems = append(problems, fmt.Sprintf("negative timeout: %d", e.TimeoutNanos))
+	}
+	if a := e.Assert; a != nil {
+		if a.HitsTotal != nil && *a.HitsTotal < 0 {
+			problems = append(problems, fmt.Sprintf("negative hits_total assertion: %d", *a.HitsTotal))
+		}
+		if a.MinHitsTotal < 0 || a.MaxTookMillis < 0 {
+			problems = append(problems, "negative min_hits_total or max_took_millis assertion")
+		}
+		if err := a.Compile(); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid assertion: %q", err))
+		}
+	}
+	u, err := url.Parse(e.URL)
+	switch {
+	case err != nil:
+		problems = append(problems, fmt.Sprintf("invalid url: %q", err))
+	case u.Scheme != "http" && u.Scheme != "https":
+		problems = append(problems, fmt.Sprintf("invalid url scheme: %q", e.URL))
+	case u.Host == "":
+		problems = append(problems, fmt.Sprintf("url without host: %q", e.URL))
+	}
+	switch {
+	case e.Source == "":
+	case err == nil && isNDJSON(u.Path):
+		for i, line := range strings.Split(e.Source, "\n") {
+			if strings.TrimSpace(line) != "" && !json.Valid([]byte(line)) {
+				problems = append(problems, fmt.Sprintf("source line %d is not valid JSON", i+1))
+			}
+		}
+	case !json.Valid([]byte(e.Source)):
+		problems = append(problems, "source is not valid JSON")
+	}
+	return problems
+}
+
+// isNDJSON returns whether requests to the URL path have newline delimited JSON bodies (msearch and bulk).
+func isNDJSON(urlPath string) bool {
+	base := path.Base(urlPath)
+	return base == "_msearch" || base == "_bulk"
+}
+
+type validateResponse struct {
+	Valid        bool `json:"valid"`
+	Explanations []struct {
+		Index string `json:"index"`
+		Valid bool   `json:"valid"`
+		Error string `json:"error"`
+	} `json:"explanations"`
+	Error json.RawMessage `json:"error"`
+}
+
+// validateQueries sends each distinct query to the target _validate/query endpoint, writing the rejected
+// ones to out. It returns the number of rejected queries.
+func validateQueries(client *esclient.Client, target string, entries []loadspec.Entry, out io.Writer) (int, error) {
+	target = strings.TrimRight(target, "/")
+	seen := make(map[string]struct{})
+	rejected := 0
+	for _, e := range entries {
+		u, err := url.Parse(e.URL)
+		if err != nil {
+			return 0, err
+		}
+		validateURL := target + "/_validate/query?explain"
+		if indices := indicesFromPath(u.Path); len(indices) > 0 {
+			validateURL = target + "/" + strings.Join(indices, ",") + "/_validate/query?explain"
+		}
+		// The validate API only accepts the query part of the search request.
+		var src map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(e.Source), &src); err != nil || src["query"] == nil {
+			continue
+		}
+		body := `{"query":` + string(src["query"]) + "}"
+		key := validateURL + body
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+
+		ctx, cancel := context.WithTimeout(context.Background(), validateTimeout)
+		req, err := esclient.NewRequest(ctx, "GET", validateURL, body, http.Header{"Content-Type": []string{"application/json"}})
+		if err != nil {
+			cancel()
+			return 0, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			cancel()
+			return 0, err
+		}
+		var vr validateResponse
+		err = json.NewDecoder(resp.Body).Decode(&vr)
+		resp.Body.Close()
+		cancel()
+		if err != nil {
+			return 0, fmt.Errorf("error parsing validate response (code:%d): %q", resp.StatusCode, err)
+		}
+		if resp.StatusCode == http.StatusOK && vr.Valid {
+			continue
+		}
+		rejected++
+		reason := string(vr.Error)
+		for _, exp := range vr.Explanations {
+			if !exp.Valid {
+				reason = exp.Error
+				break
+			}
+		}
+		fmt.Fprintf(out, "id %d: query rejected (code:%d): %s\n", e.ID, resp.StatusCode, reason)
+	}
+	return rejected, nil
+}
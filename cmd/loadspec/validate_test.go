@@ -0,0 +1,90 @@
+package loadspec
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielfireman/esperf/internal/esclient"
+	"github.com/danielfireman/esperf/loadspec"
+	"github.com/matryer/is"
+)
+
+func TestValidateEntries(t *testing.T) {
+	is := is.New(t)
+	spec := strings.Join([]string{
+		`{"delay_since_last_nanos":0,"url":"http://localhost:9200/index/_search","source":"{}","id":0}`,
+		`{"delay_since_last_nanos":-1,"url":"http://localhost:9200/index/_search","source":"{}","id":1}`,
+		`{"delay_since_last_nanos":0,"url":"%zzzzz","source":"{}","id":2}`,
+		`{"delay_since_last_nanos":0,"url":"http://localhost:9200/index/_search","source":"{","id":3}`,
+		`{"delay_since_last_nanos":0,"url":"http://localhost:9200/index/_search","source":"{}","id":0}`,
+		`{"delay_since_last_nanos":0,`,
+		`{"delay_since_last_nanos":0,"url":"localhost/index/_search","source":"{}","id":4}`,
+		`{"delay_since_last_nanos":0,"url":"http://localhost:9200/index/_search","source":"{}","id":5,"timeout_nanos":-1}`,
+		`{"delay_since_last_nanos":0,"url":"http://localhost:9200/index/_search","source":"{}","id":6,"assert":{"json_path":{"hits.total":1}}}`,
+		`{"delay_since_last_nanos":0,"url":"http://localhost:9200/index/_msearch","source":"{}\n{\"query\":{\"match_all\":{}}}\n","id":7}`,
+		`{"delay_since_last_nanos":0,"url":"http://localhost:9200/_bulk","source":"{\"index\":{}}\n{\n","id":8}`,
+	}, "\n")
+	var out bytes.Buffer
+	entries, problems, headerProblems, err := validateEntries(strings.NewReader(spec), &out)
+	is.NoErr(err)
+	is.Equal(len(entries), 2)
+	is.Equal(problems, 9)
+	is.Equal(headerProblems, 0)
+	is.True(strings.Contains(out.String(), "line 2 (id 1): negative delay"))
+	is.True(strings.Contains(out.String(), "line 3 (id 2): invalid url"))
+	is.True(strings.Contains(out.String(), "line 4 (id 3): source is not valid JSON"))
+	is.True(strings.Contains(out.String(), "line 5 (id 0): duplicated id, first seen at line 1"))
+	is.True(strings.Contains(out.String(), "line 6: invalid entry"))
+	is.True(strings.Contains(out.String(), "line 7 (id 4): invalid url scheme"))
+	is.True(strings.Contains(out.String(), "line 8 (id 5): negative timeout"))
+	is.True(strings.Contains(out.String(), "line 9 (id 6): invalid assertion"))
+	is.True(strings.Contains(out.String(), "line 11 (id 8): source line 2 is not valid JSON"))
+}
+
+func TestValidateEntries_Header(t *testing.T) {
+	is := is.New(t)
+	spec := `{"loadspec_version":999}
+{"delay_since_last_nanos":0,"url":"http://localhost:9200/index/_search","source":"{}","id":0}`
+	var out bytes.Buffer
+	entries, problems, headerProblems, err := validateEntries(strings.NewReader(spec), &out)
+	is.NoErr(err)
+	is.Equal(len(entries), 1)
+	is.Equal(problems, 0) // Header problems are not entry problems.
+	is.Equal(headerProblems, 1)
+	is.True(strings.Contains(out.String(), "line 1: unsupported loadspec version"))
+}
+
+func TestValidateQueries(t *testing.T) {
+	is := is.New(t)
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.Contains(string(body), "bad") {
+			fmt.Fprint(w, `{"valid":false,"explanations":[{"index":"index","valid":false,"error":"parsing_exception"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"valid":true}`)
+	}))
+	defer server.Close()
+
+	entries := []loadspec.Entry{
+		{ID: 0, URL: "http://other:9200/index/_search", Source: `{"size":1,"query":{"match_all":{}}}`},
+		{ID: 1, URL: "http://other:9200/index/_search", Source: `{"size":2,"query":{"match_all":{}}}`},
+		{ID: 2, URL: "http://other:9200/index/_search", Source: `{"query":{"bad":{}}}`},
+		{ID: 3, URL: "http://other:9200/index/_search", Source: `{"size":0}`},
+	}
+	client, err := esclient.New(esclient.Config{}, esclient.Options{})
+	is.NoErr(err)
+	var out bytes.Buffer
+	rejected, err := validateQueries(client, server.URL, entries, &out)
+	is.NoErr(err)
+	is.Equal(rejected, 1)
+	is.Equal(paths, []string{"/index/_validate/query", "/index/_validate/query"}) // Distinct queries only.
+	is.True(strings.Contains(out.String(), "id 2: query rejected (code:200): parsing_exception"))
+}
@@ -0,0 +1,214 @@
+package record
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/danielfireman/esperf/anon"
+	"github.com/danielfireman/esperf/internal/cmdutil"
+	"github.com/danielfireman/esperf/internal/esclient"
+	"github.com/danielfireman/esperf/loadspec"
+	"github.com/spf13/cobra"
+)
+
+var (
+	listen         string
+	upstream       string
+	outputPath     string
+	formatName     string
+	recordDuration time.Duration
+	anonymizedMap  string
+	anonFields     []string
+	injectCreds    bool
+)
+
+func init() {
+	RootCmd.Flags().StringVar(&listen, "listen", "localhost:9201", "Address the recording proxy listens on. Use :9201 to accept connections from other hosts.")
+	RootCmd.Flags().StringVar(&upstream, "upstream", "", "Elasticsearch URL (i.e. http://localhost:9200) traffic is forwarded to.")
+	RootCmd.Flags().StringVar(&outputPath, "output", "", "Path of the loadspec file. Writes to STDOUT if not set.")
+	RootCmd.Flags().StringVar(&formatName, "output_format", loadspec.DefaultFormat.String(), "Format of the recorded loadspec: json or bin, optionally compressed by appending .gz or .zst.")
+	RootCmd.Flags().DurationVar(&recordDuration, "duration", 0, "How long to record for. Zero means until SIGINT or SIGTERM is received.")
+	RootCmd.Flags().StringVar(&anonymizedMap, "anonymized_map_path", "", "Path to the dictionary of anonymized fields.")
+	RootCmd.Flags().BoolVar(&injectCreds, "inject_credentials", false, "Authenticate forwarded requests with the configured credentials (i.e. --username), unless clients send their own. Anyone able to reach --listen gets the same access to the cluster.")
+	RootCmd.Flags().StringSliceVar(&anonFields, "anon_fields", []string{}, "Name of the fields in the request body that must be anonymized. Only accept numbers and strings.")
+}
+
+// RootCmd is the root of the record command.
+var RootCmd = &cobra.Command{
+	Use:   "record",
+	Short: "Records live traffic into a loadspec.",
+	Long: `Acts as a transparent reverse proxy in front of elasticsearch, forwarding traffic to --upstream and recording
+every request (method, URL, body and arrival time) as a loadspec entry. Unlike slowlogs, all requests are recorded.
+Recording stops after --duration or when SIGINT/SIGTERM is received.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if upstream == "" {
+			return fmt.Errorf("please specify the upstream URL")
+		}
+		upstreamURL, err := url.Parse(upstream)
+		if err != nil {
+			return err
+		}
+		if upstreamURL.Scheme == "" || upstreamURL.Host == "" {
+			return fmt.Errorf("invalid upstream URL:%q", upstream)
+		}
+		format, err := loadspec.ParseFormat(formatName)
+		if err != nil {
+			return err
+		}
+
+		header := cmdutil.NewHeader(cmd, args)
+		anonymizer, err := cmdutil.NewAnonymizer(anonymizedMap, anonFields, header)
+		if err != nil {
+			return err
+		}
+
+		var out io.Writer = os.Stdout
+		if outputPath != "" {
+			f, err := os.Create(outputPath)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			out = f
+		}
+		writer, err := loadspec.NewWriter(out, format, header)
+		if err != nil {
+			return err
+		}
+		rec := newRecorder(upstreamURL, writer, anonymizer)
+		if rec.proxy.Transport, err = esclient.NewTransport(esclient.Default, esclient.Options{NoAuth: !injectCreds}); err != nil {
+			return err
+		}
+		server := &http.Server{Addr: listen, Handler: rec}
+
+		shutdownDone := make(chan struct{})
+		go func() {
+			defer close(shutdownDone)
+			sigs := make(chan os.Signal, 1)
+			signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+			defer signal.Stop(sigs)
+			var timeout <-chan time.Time
+			if recordDuration > 0 {
+				timeout = time.After(recordDuration)
+			}
+			select {
+			case <-sigs:
+			case <-timeout:
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			server.Shutdown(ctx)
+		}()
+		fmt.Fprintf(os.Stderr, "Recording traffic to %s on %s\n", upstream, listen)
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			return err
+		}
+		<-shutdownDone
+		return rec.Close()
+	},
+}
+
+// recorder is a http.Handler which records each request as a loadspec entry before forwarding it upstream.
+type recorder struct {
+	proxy      *httputil.ReverseProxy
+	upstream   *url.URL
+	anonymizer *anon.Anonymizer
+
+	// Guards fields below. Entries are written in arrival order.
+	mu      sync.Mutex
+	writer  *loadspec.Writer
+	last    time.Time
+	id      int
+	skipped int
+	err     error
+}
+
+func newRecorder(upstream *url.URL, writer *loadspec.Writer, anonymizer *anon.Anonymizer) *recorder {
+	return &recorder{
+		proxy:      httputil.NewSingleHostReverseProxy(upstream),
+		upstream:   upstream,
+		anonymizer: anonymizer,
+		writer:     writer,
+	}
+}
+
+func (r *recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	arrival := time.Now()
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.record(arrival, req, body)
+	r.proxy.ServeHTTP(w, req)
+}
+
+func (r *recorder) record(arrival time.Time, req *http.Request, body []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	entry := loadspec.Entry{
+		URL:    r.upstream.Scheme + "://" + r.upstream.Host + req.URL.RequestURI(),
+		Source: string(body),
+	}
+	if req.Method != "GET" {
+		entry.Method = req.Method
+	}
+	if r.anonymizer != nil && len(body) > 0 {
+		// Anonymizer is not safe for concurrent use, thus the lock. Bodies which can not be anonymized
+		// (i.e. msearch) are not recorded, to avoid leaking data.
+		var obj map[string]interface{}
+		if err := json.Unmarshal(body, &obj); err != nil {
+			r.skipped++
+			return
+		}
+		r.anonymizer.Anonymize(obj)
+		src, err := json.Marshal(obj)
+		if err != nil {
+			r.skipped++
+			return
+		}
+		entry.Source = string(src)
+	}
+	// Concurrent requests might get the lock out of arrival order, those are considered simultaneous.
+	if r.id > 0 && arrival.After(r.last) {
+		entry.DelaySinceLastNanos = arrival.Sub(r.last).Nanoseconds()
+	}
+	if arrival.After(r.last) {
+		r.last = arrival
+	}
+	entry.ID = r.id
+	if err := r.writer.Write(&entry); err != nil {
+		r.err = err
+		fmt.Fprintf(os.Stderr, "Error writing loadspec, recording stopped: %q\n", err)
+		return
+	}
+	r.id++
+}
+
+// Close flushes the recorded entries.
+func (r *recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Fprintf(os.Stderr, "Recorded %d requests, %d skipped.\n", r.id, r.skipped)
+	if err := r.writer.Close(); err != nil {
+		return err
+	}
+	return r.err
+}
@@ -0,0 +1,116 @@
+package record
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/danielfireman/esperf/anon"
+	"github.com/danielfireman/esperf/internal/esclient"
+	"github.com/danielfireman/esperf/loadspec"
+	"github.com/matryer/is"
+)
+
+func TestRecorder(t *testing.T) {
+	is := is.New(t)
+	var upstreamBodies []string
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		upstreamBodies = append(upstreamBodies, r.Method+" "+r.URL.RequestURI()+" "+string(b))
+		w.Write([]byte(`{"took":1}`))
+	}))
+	defer upstreamServer.Close()
+	upstreamURL, _ := url.Parse(upstreamServer.URL)
+
+	buf := &bytes.Buffer{}
+	writer, err := loadspec.NewWriter(buf, loadspec.DefaultFormat, &loadspec.Header{Command: "esperf record"})
+	is.NoErr(err)
+	rec := newRecorder(upstreamURL, writer, nil)
+	proxy := httptest.NewServer(rec)
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL + "/wiki/_search?q=foo")
+	is.NoErr(err)
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	is.Equal(string(b), `{"took":1}`)
+	resp, err = http.Post(proxy.URL+"/wiki/_search", "application/json", strings.NewReader(`{"size":0}`))
+	is.NoErr(err)
+	resp.Body.Close()
+	is.NoErr(rec.Close())
+
+	is.Equal(upstreamBodies, []string{"GET /wiki/_search?q=foo ", `POST /wiki/_search {"size":0}`})
+	h, entries, err := loadspec.ReadAll(buf)
+	is.NoErr(err)
+	is.Equal(h.Command, "esperf record")
+	is.Equal(len(entries), 2)
+	is.Equal(entries[0].URL, upstreamServer.URL+"/wiki/_search?q=foo")
+	is.Equal(entries[0].Method, "")
+	is.Equal(entries[0].DelaySinceLastNanos, int64(0))
+	is.Equal(entries[1].Method, "POST")
+	is.Equal(entries[1].Source, `{"size":0}`)
+	is.Equal(entries[1].ID, 1)
+	is.True(entries[1].DelaySinceLastNanos > 0)
+}
+
+func TestRecorder_Anonymize(t *testing.T) {
+	is := is.New(t)
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstreamServer.Close()
+	upstreamURL, _ := url.Parse(upstreamServer.URL)
+
+	buf := &bytes.Buffer{}
+	writer, err := loadspec.NewWriter(buf, loadspec.DefaultFormat, &loadspec.Header{})
+	is.NoErr(err)
+	anonymizer := &anon.Anonymizer{
+		FMap: anon.FieldsMap{"name": {"john": "foo"}},
+		FRE:  anon.MustParseFieldsRE([]string{"name::(.*)"}),
+	}
+	rec := newRecorder(upstreamURL, writer, anonymizer)
+	proxy := httptest.NewServer(rec)
+	defer proxy.Close()
+
+	resp, err := http.Post(proxy.URL+"/_search", "application/json", strings.NewReader(`{"name":"john"}`))
+	is.NoErr(err)
+	resp.Body.Close()
+	// Not a JSON object, can not be anonymized.
+	resp, err = http.Post(proxy.URL+"/_msearch", "application/json", strings.NewReader("{}\n{}\n"))
+	is.NoErr(err)
+	resp.Body.Close()
+	is.NoErr(rec.Close())
+
+	_, entries, err := loadspec.ReadAll(buf)
+	is.NoErr(err)
+	is.Equal(len(entries), 1)
+	is.Equal(entries[0].Source, `{"name":"foo"}`)
+}
+
+func TestRecorder_Credentials(t *testing.T) {
+	is := is.New(t)
+	var auth []string
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization"))
+	}))
+	defer upstreamServer.Close()
+	upstreamURL, _ := url.Parse(upstreamServer.URL)
+
+	conn := esclient.Config{BearerToken: "t0ken"}
+	for _, inject := range []bool{false, true} {
+		writer, err := loadspec.NewWriter(ioutil.Discard, loadspec.DefaultFormat, &loadspec.Header{})
+		is.NoErr(err)
+		rec := newRecorder(upstreamURL, writer, nil)
+		rec.proxy.Transport, err = esclient.NewTransport(conn, esclient.Options{NoAuth: !inject})
+		is.NoErr(err)
+		proxy := httptest.NewServer(rec)
+		resp, err := http.Get(proxy.URL + "/_search")
+		is.NoErr(err)
+		resp.Body.Close()
+		proxy.Close()
+		is.NoErr(rec.Close())
+	}
+	is.Equal(auth, []string{"", "Bearer t0ken"}) // Credentials are only added when asked for.
+}
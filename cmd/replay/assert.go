@@ -0,0 +1,87 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/danielfireman/esperf/loadspec"
+)
+
+// mismatch is an assertion a response did not pass.
+type mismatch struct {
+	assertion string
+	expected  string
+	actual    string
+}
+
+// missing is the actual value of assertions about something the response does not have.
+const missing = "missing"
+
+// evaluate checks the response to a request against the assertions, which must be compiled, returning the
+// mismatches.
+func evaluate(a *loadspec.Assertions, code int, body []byte) []mismatch {
+	var mm []mismatch
+	if a.Status != 0 && a.Status != code {
+		mm = append(mm, mismatch{"status", strconv.Itoa(a.Status), strconv.Itoa(code)})
+	}
+	// Errors are fine, all but the status are then missing.
+	var resp struct {
+		Took *int64 `json:"took"`
+		Hits struct {
+			Total *hitsTotal `json:"total"`
+		} `json:"hits"`
+	}
+	json.Unmarshal(body, &resp)
+	total := resp.Hits.Total
+	if a.HitsTotal != nil {
+		switch {
+		case total == nil:
+			mm = append(mm, mismatch{"hits_total", strconv.FormatInt(*a.HitsTotal, 10), missing})
+		case total.Value != *a.HitsTotal || total.Relation == "gte":
+			mm = append(mm, mismatch{"hits_total", strconv.FormatInt(*a.HitsTotal, 10), total.String()})
+		}
+	}
+	if a.MinHitsTotal != 0 {
+		switch {
+		case total == nil:
+			mm = append(mm, mismatch{"min_hits_total", strconv.FormatInt(a.MinHitsTotal, 10), missing})
+		case total.Value < a.MinHitsTotal:
+			mm = append(mm, mismatch{"min_hits_total", strconv.FormatInt(a.MinHitsTotal, 10), total.String()})
+		}
+	}
+	if a.MaxTookMillis != 0 {
+		switch {
+		case resp.Took == nil:
+			mm = append(mm, mismatch{"max_took_millis", strconv.FormatInt(a.MaxTookMillis, 10), missing})
+		case *resp.Took > a.MaxTookMillis:
+			mm = append(mm, mismatch{"max_took_millis", strconv.FormatInt(a.MaxTookMillis, 10), strconv.FormatInt(*resp.Took, 10)})
+		}
+	}
+	if paths := a.Paths(); len(paths) > 0 {
+		var doc interface{}
+		json.Unmarshal(body, &doc)
+		for _, p := range paths {
+			want := encode(p.Want)
+			v, ok := p.Path.Get(doc)
+			if !ok {
+				mm = append(mm, mismatch{p.Expr, want, missing})
+				continue
+			}
+			// Comparing encodings, so numbers are equal regardless of their Go type.
+			if got := encode(v); got != want {
+				mm = append(mm, mismatch{p.Expr, want, got})
+			}
+		}
+	}
+	return mm
+}
+
+// encode returns the compact JSON encoding of v.
+func encode(v interface{}) string {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(buf)
+}
@@ -0,0 +1,116 @@
+package replay
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/danielfireman/esperf/loadspec"
+	"github.com/danielfireman/esperf/reporter"
+)
+
+func TestHitsTotal_UnmarshalJSON(t *testing.T) {
+	testCases := []struct {
+		body string
+		want hitsTotal
+	}{
+		{`42`, hitsTotal{42, "eq"}},
+		{`{"value":42,"relation":"eq"}`, hitsTotal{42, "eq"}},
+		{`{"value":10000,"relation":"gte"}`, hitsTotal{10000, "gte"}},
+	}
+	for _, tc := range testCases {
+		var got hitsTotal
+		if err := json.Unmarshal([]byte(tc.body), &got); err != nil {
+			t.Fatalf("%s error got:%q want:nil", tc.body, err)
+		}
+		if got != tc.want {
+			t.Fatalf("%s got:%+v want:%+v", tc.body, got, tc.want)
+		}
+	}
+	var got hitsTotal
+	if err := json.Unmarshal([]byte(`"many"`), &got); err == nil {
+		t.Fatalf("error got:nil want:error")
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	hits := func(n int64) *int64 { return &n }
+	es6 := `{"took":12,"hits":{"total":3,"hits":[{"_id":"42","_score":1.5}]}}`
+	es7 := `{"took":12,"hits":{"total":{"value":10000,"relation":"gte"},"hits":[{"_id":"42"}]}}`
+	testCases := []struct {
+		desc string
+		a    loadspec.Assertions
+		code int
+		body string
+		want []mismatch
+	}{
+		{"AllPass", loadspec.Assertions{Status: 200, HitsTotal: hits(3), MinHitsTotal: 1, MaxTookMillis: 20, JSONPath: map[string]interface{}{"$.hits.hits[0]._id": "42", "$.hits.hits[0]._score": 1.5}}, 200, es6, nil},
+		{"Status", loadspec.Assertions{Status: 200}, 404, `{}`, []mismatch{{"status", "200", "404"}}},
+		{"HitsTotal", loadspec.Assertions{HitsTotal: hits(4)}, 200, es6, []mismatch{{"hits_total", "4", "3"}}},
+		{"HitsTotalLowerBound", loadspec.Assertions{HitsTotal: hits(10000), MinHitsTotal: 5000}, 200, es7, []mismatch{{"hits_total", "10000", ">=10000"}}},
+		{"MinHitsTotal", loadspec.Assertions{MinHitsTotal: 5}, 200, es6, []mismatch{{"min_hits_total", "5", "3"}}},
+		{"MaxTook", loadspec.Assertions{MaxTookMillis: 10}, 200, es6, []mismatch{{"max_took_millis", "10", "12"}}},
+		{"JSONPath", loadspec.Assertions{JSONPath: map[string]interface{}{"$.hits.hits[0]._id": "43", "$.hits.hits[1]._id": "44"}}, 200, es6, []mismatch{{"$.hits.hits[0]._id", `"43"`, `"42"`}, {"$.hits.hits[1]._id", `"44"`, missing}}},
+		{"NotJSON", loadspec.Assertions{Status: 200, HitsTotal: hits(1), MaxTookMillis: 10}, 502, "<html>", []mismatch{{"status", "200", "502"}, {"hits_total", "1", missing}, {"max_took_millis", "10", missing}}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if err := tc.a.Compile(); err != nil {
+				t.Fatalf("error got:%q want:nil", err)
+			}
+			got := evaluate(&tc.a, tc.code, []byte(tc.body))
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got:%v want:%v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRunOpen_Assertions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"took":1,"hits":{"total":{"value":3,"relation":"eq"}}}`))
+	}))
+	defer server.Close()
+
+	r := newTestRun(t, 1)
+	defer r.close()
+	path := filepath.Join(r.dir, "assertions.csv")
+	var err error
+	if r.mismatches, err = reporter.NewMismatchReport(path); err != nil {
+		t.Fatalf("error got:%q want:nil", err)
+	}
+	r.mismatches.Start()
+
+	three := int64(3)
+	book := []loadspec.Entry{
+		{ID: 0, URL: server.URL, Assert: &loadspec.Assertions{HitsTotal: &three}},
+		{ID: 1, URL: server.URL, Assert: &loadspec.Assertions{MinHitsTotal: 5}},
+		{ID: 2, URL: server.URL},
+	}
+	err = r.runOpen(book, make(chan os.Signal))
+	r.mismatches.Finish()
+	if err != nil {
+		t.Fatalf("error got:%q want:nil", err)
+	}
+	if got := r.assertionFailures.Get(); got != 1 {
+		t.Fatalf("assertion failures got:%d want:1", got)
+	}
+	// Responses failing assertions are still completed requests.
+	if got := r.completed.Get(); got != 3 {
+		t.Fatalf("completed got:%d want:3", got)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error got:%q want:nil", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[1], ",1,200,min_hits_total,5,3") {
+		t.Fatalf("got:\n%s want one min_hits_total mismatch of entry 1", b)
+	}
+}
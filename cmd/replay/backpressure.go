@@ -0,0 +1,129 @@
+package replay
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Back-pressure policies, i.e. how replay reacts to 429 and 503 responses.
+const (
+	// Pause dispatching for Retry-After, dropping entries which would have fired during the pause.
+	pauseDropPolicy = "pause_drop"
+	// Pause dispatching for Retry-After, shifting the rest of the schedule.
+	pauseDelayPolicy = "pause_delay"
+	// Retry the request using exponential backoff with jitter.
+	retryPolicy = "retry"
+	// Only count the response.
+	ignorePolicy = "ignore"
+)
+
+var (
+	backpressure      string
+	retryMax          int
+	retryBackoff      time.Duration
+	retryMaxBackoff   time.Duration
+	defaultRetryAfter time.Duration
+)
+
+// addBackpressureFlags adds the back-pressure flags to commands which fire requests.
+func addBackpressureFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&backpressure, "backpressure", pauseDropPolicy, "How to react to 429 and 503 responses: pause_drop (pause dispatching for Retry-After and drop entries which would fire meanwhile), pause_delay (pause dispatching for Retry-After and shift the schedule), retry (retry the request with exponential backoff and jitter) or ignore (only count them).")
+	cmd.Flags().IntVar(&retryMax, "retry_max", 3, "Maximum number of retries of a request, retry policy only. Requests still throttled after that are errors.")
+	cmd.Flags().DurationVar(&retryBackoff, "retry_backoff", 100*time.Millisecond, "Base backoff of the retry policy, doubled at each retry. Retry-After is honoured if longer.")
+	cmd.Flags().DurationVar(&retryMaxBackoff, "retry_max_backoff", 10*time.Second, "Maximum backoff of the retry policy.")
+	cmd.Flags().DurationVar(&defaultRetryAfter, "default_retry_after", time.Second, "Pause used by the pause policies when the response has no valid Retry-After header.")
+}
+
+func checkBackpressureFlags() error {
+	switch backpressure {
+	case pauseDropPolicy, pauseDelayPolicy, retryPolicy, ignorePolicy:
+	default:
+		return fmt.Errorf("invalid back-pressure policy:%q", backpressure)
+	}
+	if retryMax < 0 || retryBackoff <= 0 || retryMaxBackoff < retryBackoff {
+		return fmt.Errorf("invalid retry settings: max:%d backoff:%v max_backoff:%v", retryMax, retryBackoff, retryMaxBackoff)
+	}
+	if defaultRetryAfter < 0 {
+		return fmt.Errorf("invalid default retry-after:%v", defaultRetryAfter)
+	}
+	return nil
+}
+
+// onThrottled applies the back-pressure policy to a 429 or 503 response to the attempt-th try of a request
+// (starting at zero). It returns whether the request must be retried and how long to wait before doing so.
+func (r *runner) onThrottled(resp *http.Response, attempt int, pauseChan chan<- time.Duration) (time.Duration, bool) {
+	r.throttled.Inc()
+	switch backpressure {
+	case ignorePolicy:
+		return 0, false
+	case retryPolicy:
+		if attempt >= retryMax {
+			r.errors.Inc()
+			r.errorCounters.inc(exhaustedError, "")
+			return 0, false
+		}
+		wait := backoff(attempt, rand.Int63)
+		if ra, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok && ra > wait {
+			wait = ra
+		}
+		return wait, true
+	}
+
+	// Pause policies.
+	// If the loadtest is paused, ignore this signal.
+	if atomic.LoadInt32(&r.paused) == 1 {
+		return 0, false
+	}
+	pt, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		pt = defaultRetryAfter
+	}
+	r.pauseTimes.Record(pt.Nanoseconds() / int64(time.Millisecond))
+	// Only enqueue if the pause queue is empty.
+	if atomic.CompareAndSwapInt32(&r.paused, 0, 1) {
+		pauseChan <- pt
+	}
+	return 0, false
+}
+
+// parseRetryAfter parses the Retry-After header value, either delay seconds or an HTTP-date.
+// It returns false if the header is empty or malformed.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseFloat(value, 64); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs * float64(time.Second)), true
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+// backoff returns how long to wait before retrying the attempt-th try: a random duration between zero and
+// --retry_backoff * 2^attempt, capped at --retry_max_backoff (full jitter).
+func backoff(attempt int, rnd func() int64) time.Duration {
+	ceil := retryMaxBackoff
+	if attempt < 62 {
+		if d := retryBackoff << uint(attempt); d > 0 && d < ceil {
+			ceil = d
+		}
+	}
+	return time.Duration(rnd() % (int64(ceil) + 1))
+}
@@ -0,0 +1,143 @@
+package replay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/danielfireman/esperf/loadspec"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2017, 10, 10, 10, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"2", 2 * time.Second, true},
+		{"0.5", 500 * time.Millisecond, true},
+		{"-1", 0, false},
+		{"Tue, 10 Oct 2017 10:00:30 GMT", 30 * time.Second, true},
+		{"Tue, 10 Oct 2017 09:00:00 GMT", 0, true},
+		{"tomorrow", 0, false},
+	}
+	for _, tc := range testCases {
+		got, ok := parseRetryAfter(tc.value, now)
+		if got != tc.want || ok != tc.ok {
+			t.Fatalf("%q got:%v,%v want:%v,%v", tc.value, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	defer restoreGlobals(&retryBackoff, &retryMaxBackoff)()
+	retryBackoff, retryMaxBackoff = 100*time.Millisecond, time.Second
+	max := func() int64 { return 1<<63 - 1 }
+	testCases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		{4, time.Second},
+		{100, time.Second},
+	}
+	for _, tc := range testCases {
+		// Jitter is between zero and the ceil.
+		if got := backoff(tc.attempt, max); got > tc.want {
+			t.Fatalf("attempt %d got:%v want:<=%v", tc.attempt, got, tc.want)
+		}
+		if got := backoff(tc.attempt, func() int64 { return int64(tc.want) }); got != tc.want {
+			t.Fatalf("attempt %d got:%v want:%v", tc.attempt, got, tc.want)
+		}
+		if got := backoff(tc.attempt, func() int64 { return 0 }); got != 0 {
+			t.Fatalf("attempt %d got:%v want:0", tc.attempt, got)
+		}
+	}
+}
+
+func TestBackpressure(t *testing.T) {
+	run := func(t *testing.T, policy string, throttle int32, book []loadspec.Entry) (*runner, int32, string) {
+		var total int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if atomic.AddInt32(&total, 1) <= throttle {
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.Write([]byte(`{"took":1}`))
+		}))
+		defer server.Close()
+		for i := range book {
+			book[i].URL = server.URL
+		}
+
+		r := newTestRun(t, 1)
+		defer r.close()
+
+		defer restoreGlobals(&backpressure, &retryMax, &retryBackoff, &retryMaxBackoff, &defaultRetryAfter)()
+		backpressure, retryMax, retryBackoff, retryMaxBackoff, defaultRetryAfter = policy, 2, time.Millisecond, time.Millisecond, 100*time.Millisecond
+		if err := r.runOpen(book, make(chan os.Signal)); err != nil {
+			t.Fatalf("error got:%q want:nil", err)
+		}
+		return r.runner, total, r.rows()
+	}
+	// Entries every 30ms, the 100ms pause covers 3 of them.
+	newBook := func() []loadspec.Entry {
+		book := make([]loadspec.Entry, 6)
+		for i := range book {
+			book[i] = loadspec.Entry{ID: i, DelaySinceLastNanos: int64(30 * time.Millisecond)}
+		}
+		return book
+	}
+
+	t.Run("PauseDrop", func(t *testing.T) {
+		r, total, rows := run(t, pauseDropPolicy, 1, newBook())
+		if int64(total)+r.dropped.Get() != 6 || r.dropped.Get() < 3 || r.throttled.Get() != 1 {
+			t.Fatalf("requests:%d dropped:%d throttled:%d want:6 entries, >=3 dropped, 1 throttled", total, r.dropped.Get(), r.throttled.Get())
+		}
+		if got := int64(strings.Count(rows, ",dropped,")); got != r.dropped.Get() {
+			t.Fatalf("dropped rows got:%d want:%d\n%s", got, r.dropped.Get(), rows)
+		}
+		if got := strings.Count(rows, ",throttled,"); got != 1 {
+			t.Fatalf("throttled rows got:%d want:1\n%s", got, rows)
+		}
+		s := r.summary(len(newBook()), time.Second, nil)
+		if s.EntriesDispatched+s.EntriesDropped != 6 || s.EntriesNotDispatched != 0 || s.Completed+s.Throttled != s.Requests {
+			t.Fatalf("summary does not reconcile: %+v", s)
+		}
+	})
+	t.Run("PauseDelay", func(t *testing.T) {
+		r, total, _ := run(t, pauseDelayPolicy, 1, newBook())
+		if total != 6 || r.dropped.Get() != 0 || r.throttled.Get() != 1 {
+			t.Fatalf("requests:%d dropped:%d throttled:%d want:6,0,1", total, r.dropped.Get(), r.throttled.Get())
+		}
+	})
+	t.Run("Retry", func(t *testing.T) {
+		r, total, _ := run(t, retryPolicy, 2, newBook()[:1])
+		if total != 3 || r.retries.Get() != 2 || r.errors.Get() != 0 || r.responseTimes.Snapshot().Count() != 1 {
+			t.Fatalf("requests:%d retries:%d errors:%d want:3,2,0", total, r.retries.Get(), r.errors.Get())
+		}
+	})
+	t.Run("RetryExhausted", func(t *testing.T) {
+		r, total, _ := run(t, retryPolicy, 5, newBook()[:1])
+		if total != 3 || r.retries.Get() != 2 || r.errors.Get() != 1 {
+			t.Fatalf("requests:%d retries:%d errors:%d want:3,2,1", total, r.retries.Get(), r.errors.Get())
+		}
+		if got := r.errorCounters.byClass()[exhaustedError]; got != 1 {
+			t.Fatalf("retries exhausted got:%d want:1", got)
+		}
+	})
+	t.Run("Ignore", func(t *testing.T) {
+		r, total, _ := run(t, ignorePolicy, 2, newBook())
+		if total != 6 || r.throttled.Get() != 2 || r.dropped.Get() != 0 || r.retries.Get() != 0 {
+			t.Fatalf("requests:%d throttled:%d dropped:%d retries:%d", total, r.throttled.Get(), r.dropped.Get(), r.retries.Get())
+		}
+	})
+}
@@ -0,0 +1,291 @@
+package replay
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/danielfireman/esperf/loadspec"
+	"github.com/spf13/cobra"
+)
+
+const (
+	stepStrategy   = "step"
+	binaryStrategy = "binary"
+)
+
+var (
+	strategy     string
+	startQPS     float64
+	stepQPS      float64
+	maxQPS       float64
+	precisionQPS float64
+	stepDuration time.Duration
+	arrivalDist  string
+	maxP99       time.Duration
+	maxErrorRate float64
+	minQPSRatio  float64
+)
+
+func init() {
+	CapacityCmd.Flags().StringVar(&strategy, "strategy", stepStrategy, "How the arrival rate is changed: step (from --start_qps to --max_qps, increasing by --step_qps) or binary (binary search between --start_qps and --max_qps).")
+	CapacityCmd.Flags().Float64Var(&startQPS, "start_qps", 10, "Arrival rate of the first step.")
+	CapacityCmd.Flags().Float64Var(&stepQPS, "step_qps", 10, "Arrival rate increment of the step strategy.")
+	CapacityCmd.Flags().Float64Var(&maxQPS, "max_qps", 1000, "Maximum arrival rate.")
+	CapacityCmd.Flags().Float64Var(&precisionQPS, "precision_qps", 5, "The binary search stops when the sustainable and unsustainable arrival rates are this close.")
+	CapacityCmd.Flags().DurationVar(&stepDuration, "step_duration", 30*time.Second, "Duration of each step.")
+	CapacityCmd.Flags().StringVar(&arrivalDist, "arrival", "poisson", "Inter-arrival time distribution of each step: const or poisson.")
+	CapacityCmd.Flags().DurationVar(&maxP99, "max_p99", 200*time.Millisecond, "A step is sustainable if the 99th percentile of the response time (took) is below this.")
+	CapacityCmd.Flags().Float64Var(&maxErrorRate, "max_error_rate", 0.01, "A step is sustainable if the ratio of errors is below this.")
+	CapacityCmd.Flags().Float64Var(&minQPSRatio, "min_qps_ratio", 0.8, "A step is sustainable if the achieved arrival rate is at least this ratio of the target one.")
+	CapacityCmd.Flags().StringVar(&resultsPath, "results_path", "", "Directory where per-request results of each step are written to.")
+	CapacityCmd.Flags().StringVar(&expID, "exp_id", "1", "")
+	CapacityCmd.Flags().DurationVar(&timeout, "timeout", 30*time.Second, "Timeout to be used in connections to ES.")
+	CapacityCmd.Flags().BoolVar(&debug, "debug", false, "Dump requests and responses.")
+	CapacityCmd.Flags().BoolVar(&gzipRequests, "gzip", false, "Compress request bodies. Compressed responses are always decompressed.")
+	CapacityCmd.Flags().IntVarP(&numClients, "num_clients", "c", 10, "Number of active clients making requests.")
+	CapacityCmd.Flags().BoolVar(&continueOn400, "continue_on_400s", false, "Whether the loadtest should continue if it receives a 400 response.")
+	CapacityCmd.Flags().BoolVar(&partialAsErr, "partial_as_error", false, "Whether 200 responses with partial results (shard failures, timed out or terminated early) count as errors.")
+	CapacityCmd.Flags().Int64Var(&seed, "seed", 0, "Seed of the random number generator. Zero means a time-based seed.")
+	CapacityCmd.Flags().DurationVar(&drainTimeout, "drain_timeout", 30*time.Second, "How long an interrupted (SIGINT or SIGTERM) step waits for in-flight requests before cancelling them.")
+	addBackpressureFlags(CapacityCmd)
+	CapacityCmd.Flags().VarP(&headers, "headers", "H", "Custom HTTP headers. You can specify as many as needed by repeating the flag. \"Content-Type: application/json\" is added by default.")
+}
+
+// CapacityCmd searches for the maximum sustainable throughput.
+var CapacityCmd = &cobra.Command{
+	Use:   "capacity",
+	Short: "Finds the maximum arrival rate the cluster sustains.",
+	Long: `Finds the maximum arrival rate the cluster sustains, stepping or binary-searching the arrival rate. Each step
+fires requests picked from the loadspec read from STDIN (in order, cycling) following --arrival at the step rate for
+--step_duration. A step is sustainable if the response time p99 and the error rate are below --max_p99 and
+--max_error_rate, and the achieved arrival rate is at least --min_qps_ratio of the target one. Rejected (429 and 503)
+requests and entries dropped during pauses count as errors. The curve (one row per step) is written to STDOUT as CSV.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if resultsPath == "" {
+			return fmt.Errorf("results path can not be empty. Please set --results_path flag")
+		}
+		if numClients < 1 {
+			return fmt.Errorf("number of clients must be positive")
+		}
+		if startQPS <= 0 || maxQPS < startQPS || stepQPS <= 0 || precisionQPS <= 0 {
+			return fmt.Errorf("invalid arrival rates: start:%v step:%v max:%v precision:%v", startQPS, stepQPS, maxQPS, precisionQPS)
+		}
+		if minQPSRatio < 0 || minQPSRatio > 1 {
+			return fmt.Errorf("invalid minimum arrival rate ratio:%v", minQPSRatio)
+		}
+		if arrivalDist != "const" && arrivalDist != "poisson" {
+			return fmt.Errorf("invalid arrival distribution:%q", arrivalDist)
+		}
+		if err := checkBackpressureFlags(); err != nil {
+			return err
+		}
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		_, pool, err := loadspec.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		if len(pool) == 0 {
+			return fmt.Errorf("loadspec has no entries")
+		}
+
+		// Interruptions stop the current step and the search.
+		var interrupted int32
+		sig := make(chan os.Signal, 1)
+		stepSig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(sig)
+		go func() {
+			for s := range sig {
+				atomic.StoreInt32(&interrupted, 1)
+				select {
+				case stepSig <- s:
+				default:
+				}
+			}
+		}()
+		rnd := rand.New(rand.NewSource(seed))
+		step := 0
+		run := func(qps float64) (stepResult, error) {
+			if atomic.LoadInt32(&interrupted) == 1 {
+				return stepResult{}, fmt.Errorf("capacity search interrupted")
+			}
+			book, err := stepBook(pool, qps, stepDuration, arrivalDist, rnd)
+			if err != nil {
+				return stepResult{}, err
+			}
+			step++
+			return runStep(qps, book, csvFilePath("request", fmt.Sprintf("%s.step%d", expID, step), resultsPath), stepSig)
+		}
+
+		w := csv.NewWriter(os.Stdout)
+		defer w.Flush()
+		writeStepHeader(w)
+		knee, err := searchCapacity(strategy, run, func(s stepResult) {
+			writeStepResult(w, s)
+			w.Flush()
+		})
+		if err != nil {
+			return err
+		}
+		if knee == 0 {
+			fmt.Fprintf(os.Stderr, "No sustainable arrival rate found, even %.2f qps is too much.\n", startQPS)
+		} else {
+			fmt.Fprintf(os.Stderr, "Maximum sustainable arrival rate: %.2f qps\n", knee)
+		}
+		return w.Error()
+	},
+}
+
+type stepResult struct {
+	targetQPS   float64
+	achievedQPS float64
+	requests    int64
+	errors      int64
+	errorRate   float64
+	p50Millis   float64
+	p99Millis   float64
+	pass        bool
+}
+
+// searchCapacity changes the arrival rate following the strategy and returns the maximum sustainable
+// arrival rate found, zero if none. Each step result is passed to out.
+func searchCapacity(strategy string, run func(qps float64) (stepResult, error), out func(stepResult)) (float64, error) {
+	knee := 0.0
+	check := func(qps float64) (bool, error) {
+		s, err := run(qps)
+		if err != nil {
+			return false, err
+		}
+		out(s)
+		if s.pass && qps > knee {
+			knee = qps
+		}
+		return s.pass, nil
+	}
+	switch strategy {
+	case stepStrategy:
+		for qps := startQPS; qps <= maxQPS; qps += stepQPS {
+			pass, err := check(qps)
+			if err != nil {
+				return knee, err
+			}
+			if !pass {
+				break
+			}
+		}
+	case binaryStrategy:
+		pass, err := check(startQPS)
+		if err != nil || !pass {
+			return knee, err
+		}
+		if pass, err = check(maxQPS); err != nil || pass {
+			return knee, err
+		}
+		lo, hi := startQPS, maxQPS
+		for hi-lo > precisionQPS {
+			mid := (lo + hi) / 2
+			pass, err := check(mid)
+			if err != nil {
+				return knee, err
+			}
+			if pass {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+	default:
+		return 0, fmt.Errorf("invalid strategy:%q", strategy)
+	}
+	return knee, nil
+}
+
+// stepBook returns the entries of one step: pool entries (cycling) at qps, for d.
+func stepBook(pool []loadspec.Entry, qps float64, d time.Duration, dist string, rnd *rand.Rand) ([]loadspec.Entry, error) {
+	iaGen, err := loadspec.NewInterArrival(dist+":"+strconv.FormatFloat(qps, 'f', -1, 64), rnd)
+	if err != nil {
+		return nil, err
+	}
+	var book []loadspec.Entry
+	ia := int64(0)
+	for t := int64(0); t < d.Nanoseconds(); t += ia {
+		e := pool[len(book)%len(pool)]
+		e.DelaySinceLastNanos = ia
+		book = append(book, e)
+		ia = iaGen.Next()
+	}
+	return book, nil
+}
+
+// runStep fires the step entries and evaluates whether the step is sustainable. Interrupted steps are not
+// evaluated, as they did not last long enough.
+func runStep(qps float64, book []loadspec.Entry, perRequestPath string, sig <-chan os.Signal) (stepResult, error) {
+	sr, err := newRunner(numClients, perRequestPath)
+	if err != nil {
+		return stepResult{}, err
+	}
+	// Each step has its own clients, connections must not pile up across steps.
+	defer sr.closeIdleConnections()
+	sr.perRequest.Start()
+	start := time.Now()
+	err = sr.runOpen(book, sig)
+	elapsed := time.Since(start)
+	sr.perRequest.Finish()
+	if err != nil {
+		return stepResult{}, err
+	}
+	if sr.interruptedBy != nil {
+		return stepResult{}, fmt.Errorf("capacity search interrupted by %s, the %.2f qps step was discarded", sr.interruptedBy, qps)
+	}
+	return evalStep(qps, sr, elapsed), nil
+}
+
+func evalStep(qps float64, sr *runner, elapsed time.Duration) stepResult {
+	s := stepResult{targetQPS: qps}
+	s.requests, s.errors = sr.outcomes()
+	if elapsed > 0 {
+		// Retries do not count towards the arrival rate.
+		s.achievedQPS = float64(sr.requestsSent.Get()-sr.retries.Get()) / elapsed.Seconds()
+	}
+	if s.requests > 0 {
+		s.errorRate = float64(s.errors) / float64(s.requests)
+	}
+	snapshot := sr.responseTimes.Snapshot()
+	if snapshot.Count() > 0 {
+		q := snapshot.Quantile(0.5, 0.99)
+		s.p50Millis, s.p99Millis = q[0], q[1]
+	} else {
+		// Nothing succeeded.
+		s.p50Millis, s.p99Millis = math.Inf(1), math.Inf(1)
+	}
+	maxP99Millis := float64(maxP99) / float64(time.Millisecond)
+	s.pass = s.requests > 0 && s.p99Millis <= maxP99Millis && s.errorRate <= maxErrorRate && s.achievedQPS >= minQPSRatio*qps
+	return s
+}
+
+func writeStepHeader(w *csv.Writer) {
+	w.Write([]string{"target_qps", "achieved_qps", "requests", "errors", "error_rate", "p50_millis", "p99_millis", "sustainable"})
+}
+
+func writeStepResult(w *csv.Writer, s stepResult) {
+	w.Write([]string{
+		fmt.Sprintf("%.2f", s.targetQPS),
+		fmt.Sprintf("%.2f", s.achievedQPS),
+		strconv.FormatInt(s.requests, 10),
+		strconv.FormatInt(s.errors, 10),
+		fmt.Sprintf("%.4f", s.errorRate),
+		fmt.Sprintf("%.2f", s.p50Millis),
+		fmt.Sprintf("%.2f", s.p99Millis),
+		strconv.FormatBool(s.pass),
+	})
+}
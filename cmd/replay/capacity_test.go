@@ -0,0 +1,173 @@
+package replay
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danielfireman/esperf/loadspec"
+	"github.com/danielfireman/esperf/metrics"
+)
+
+func TestSearchCapacity(t *testing.T) {
+	defer restoreGlobals(&startQPS, &stepQPS, &maxQPS, &precisionQPS)()
+	startQPS, stepQPS, maxQPS, precisionQPS = 10, 10, 100, 5
+	// Knee at 55 qps.
+	var tried []float64
+	run := func(qps float64) (stepResult, error) {
+		tried = append(tried, qps)
+		return stepResult{targetQPS: qps, pass: qps <= 55}, nil
+	}
+	var results []stepResult
+	out := func(s stepResult) { results = append(results, s) }
+
+	t.Run("Step", func(t *testing.T) {
+		tried, results = nil, nil
+		knee, err := searchCapacity(stepStrategy, run, out)
+		if err != nil {
+			t.Fatalf("error got:%q want:nil", err)
+		}
+		if knee != 50 {
+			t.Fatalf("knee got:%v want:50", knee)
+		}
+		if len(tried) != 6 || len(results) != 6 {
+			t.Fatalf("steps got:%v want:[10 20 30 40 50 60]", tried)
+		}
+	})
+	t.Run("Binary", func(t *testing.T) {
+		tried, results = nil, nil
+		knee, err := searchCapacity(binaryStrategy, run, out)
+		if err != nil {
+			t.Fatalf("error got:%q want:nil", err)
+		}
+		if knee < 50 || knee > 55 {
+			t.Fatalf("knee got:%v want:[50,55]", knee)
+		}
+		if tried[0] != 10 || tried[1] != 100 {
+			t.Fatalf("steps got:%v want:[10 100 ...]", tried)
+		}
+	})
+	t.Run("NothingSustainable", func(t *testing.T) {
+		knee, err := searchCapacity(binaryStrategy, func(qps float64) (stepResult, error) {
+			return stepResult{}, nil
+		}, out)
+		if err != nil || knee != 0 {
+			t.Fatalf("got:%v,%q want:0,nil", knee, err)
+		}
+	})
+	t.Run("InvalidStrategy", func(t *testing.T) {
+		if _, err := searchCapacity("foo", run, out); err == nil {
+			t.Fatalf("error got:nil want:error")
+		}
+	})
+}
+
+func TestStepBook(t *testing.T) {
+	pool := []loadspec.Entry{{ID: 0, URL: "a"}, {ID: 1, URL: "b"}}
+	book, err := stepBook(pool, 10, time.Second, "const", rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatalf("error got:%q want:nil", err)
+	}
+	if len(book) != 10 {
+		t.Fatalf("entries got:%d want:10", len(book))
+	}
+	if book[0].DelaySinceLastNanos != 0 || book[1].DelaySinceLastNanos != int64(100*time.Millisecond) {
+		t.Fatalf("unexpected delays:%d,%d", book[0].DelaySinceLastNanos, book[1].DelaySinceLastNanos)
+	}
+	if book[2].URL != "a" || book[3].URL != "b" {
+		t.Fatalf("entries must cycle through the pool")
+	}
+}
+
+func TestEvalStep(t *testing.T) {
+	defer restoreGlobals(&maxP99, &maxErrorRate, &minQPSRatio)()
+	maxP99, maxErrorRate, minQPSRatio = 100*time.Millisecond, 0.1, 0.8
+	newStepRunner := func(requests, errors int, took int64) *runner {
+		sr := &runner{requestsSent: metrics.NewCounter(), errors: metrics.NewCounter(), timeouts: metrics.NewCounter(), throttled: metrics.NewCounter(), dropped: metrics.NewCounter(), retries: metrics.NewCounter(), responseTimes: metrics.NewHistogram()}
+		sr.errorCounters = newErrorCounters(sr.timeouts, sr.throttled)
+		for i := 0; i < requests; i++ {
+			sr.requestsSent.Inc()
+			if i < errors {
+				sr.errors.Inc()
+			} else {
+				sr.responseTimes.Record(took)
+			}
+		}
+		return sr
+	}
+	if s := evalStep(10, newStepRunner(100, 5, 50), 10*time.Second); !s.pass || s.achievedQPS != 10 {
+		t.Fatalf("got:%+v want sustainable at 10 qps", s)
+	}
+	if s := evalStep(10, newStepRunner(100, 20, 50), 10*time.Second); s.pass {
+		t.Fatalf("got:%+v want not sustainable due to errors", s)
+	}
+	sr := newStepRunner(100, 0, 50)
+	for i := 0; i < 20; i++ {
+		sr.timeouts.Inc()
+	}
+	if s := evalStep(10, sr, 10*time.Second); s.pass {
+		t.Fatalf("got:%+v want not sustainable due to timeouts", s)
+	}
+	sr = newStepRunner(100, 0, 50)
+	for i := 0; i < 20; i++ {
+		sr.throttled.Inc()
+	}
+	if s := evalStep(10, sr, 10*time.Second); s.pass {
+		t.Fatalf("got:%+v want not sustainable due to rejections", s)
+	}
+	sr = newStepRunner(100, 0, 50)
+	for i := 0; i < 20; i++ {
+		sr.dropped.Inc()
+	}
+	if s := evalStep(10, sr, 10*time.Second); s.pass || s.requests != 120 {
+		t.Fatalf("got:%+v want not sustainable due to dropped entries", s)
+	}
+	// Exhausted retries are not counted twice, retries do not count towards the arrival rate.
+	sr = newStepRunner(100, 0, 50)
+	for i := 0; i < 5; i++ {
+		sr.requestsSent.Inc()
+		sr.retries.Inc()
+		sr.throttled.Inc()
+	}
+	sr.errors.Inc()
+	sr.errorCounters.inc(exhaustedError, "")
+	if s := evalStep(10, sr, 10*time.Second); !s.pass || s.errors != 5 || s.achievedQPS != 10 {
+		t.Fatalf("got:%+v want sustainable with 5 errors at 10 qps", s)
+	}
+	if s := evalStep(10, newStepRunner(70, 0, 50), 10*time.Second); s.pass {
+		t.Fatalf("got:%+v want not sustainable, achieved 7 of 10 qps", s)
+	}
+	if s := evalStep(10, newStepRunner(100, 0, 500), 10*time.Second); s.pass {
+		t.Fatalf("got:%+v want not sustainable due to latency", s)
+	}
+	if s := evalStep(10, newStepRunner(10, 10, 0), 10*time.Second); s.pass {
+		t.Fatalf("got:%+v want not sustainable, nothing succeeded", s)
+	}
+}
+
+func TestRunStep_Interrupted(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"took":1}`))
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatalf("error got:%q want:nil", err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer restoreGlobals(&numClients, &maxP99, &maxErrorRate, &minQPSRatio)()
+	numClients, maxP99, maxErrorRate, minQPSRatio = 1, time.Hour, 1, 0
+	book := []loadspec.Entry{{ID: 0, URL: server.URL}, {ID: 1, URL: server.URL, DelaySinceLastNanos: int64(time.Hour)}}
+	sig := make(chan os.Signal, 1)
+	time.AfterFunc(50*time.Millisecond, func() { sig <- os.Interrupt })
+	// The first request succeeded, but the step did not last its whole duration.
+	if _, err := runStep(10, book, filepath.Join(dir, "request.csv"), sig); err == nil {
+		t.Fatalf("error got:nil want:error")
+	}
+}
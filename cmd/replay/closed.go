@@ -0,0 +1,221 @@
+package replay
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/danielfireman/esperf/loadspec"
+)
+
+const (
+	openLoopMode   = "open"
+	closedLoopMode = "closed"
+
+	sequentialOrder = "sequential"
+	randomOrder     = "random"
+)
+
+func checkClosedLoopFlags() error {
+	if numUsers < 1 {
+		return fmt.Errorf("number of users must be positive")
+	}
+	if order != sequentialOrder && order != randomOrder {
+		return fmt.Errorf("invalid order:%q", order)
+	}
+	if order == randomOrder && duration <= 0 {
+		return fmt.Errorf("random order requires a positive duration")
+	}
+	if _, err := newThinkTime(thinkTimeDef, rand.New(rand.NewSource(1))); err != nil {
+		return err
+	}
+	return nil
+}
+
+// runClosed runs numUsers virtual users. Each one picks an entry, fires it, waits for the response and
+// thinks before picking the next one. Entry delays are ignored. Users stop when the load test fails or is aborted.
+func (r *runner) runClosed(replayBook []loadspec.Entry, sig <-chan os.Signal) error {
+	if len(replayBook) == 0 {
+		return nil
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	stopAll := func() { stopOnce.Do(func() { close(stop) }) }
+	if duration > 0 {
+		timer := time.AfterFunc(duration, stopAll)
+		defer timer.Stop()
+	}
+
+	// Pauses requested by the server hold all virtual users. Stopping the load test ends the pause, so users
+	// can return.
+	var pauseGate sync.RWMutex
+	pauseChan := make(chan time.Duration, 1)
+	go func() {
+		for pt := range pauseChan {
+			pauseGate.Lock()
+			timer := time.NewTimer(pt)
+			select {
+			case <-timer.C:
+			case <-stop:
+			case <-r.ctx.Done():
+			}
+			timer.Stop()
+			atomic.StoreInt32(&r.paused, 0)
+			pauseGate.Unlock()
+		}
+	}()
+
+	picker := newEntryPicker(replayBook, order)
+	var wg sync.WaitGroup
+	for i := 0; i < numUsers; i++ {
+		rnd := rand.New(rand.NewSource(seed + int64(i)))
+		// Flags were already checked.
+		think, _ := newThinkTime(thinkTimeDef, rnd)
+		client := <-r.clients
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-r.ctx.Done():
+					return
+				default:
+				}
+				entry, ok := picker.next(rnd)
+				if !ok {
+					return
+				}
+				pauseGate.RLock()
+				pauseGate.RUnlock()
+				r.fire(client, entry, pauseChan)
+				if t := think.Next(); t > 0 {
+					select {
+					case <-stop:
+						return
+					case <-r.ctx.Done():
+						return
+					case <-time.After(t):
+					}
+				}
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case s := <-sig:
+		r.interrupt(s, sig)
+		stopAll()
+		r.wait(done)
+	case <-r.stop:
+		stopAll()
+		<-done
+	}
+	close(pauseChan)
+	return r.failed()
+}
+
+// entryPicker hands out loadspec entries to virtual users. It is safe for concurrent use.
+type entryPicker struct {
+	entries []loadspec.Entry
+	random  bool
+	// Index of the next entry, sequential order only.
+	i int64
+	// Sequential order without a duration issues each entry once.
+	once bool
+}
+
+func newEntryPicker(entries []loadspec.Entry, order string) *entryPicker {
+	return &entryPicker{
+		entries: entries,
+		random:  order == randomOrder,
+		once:    duration <= 0,
+	}
+}
+
+// next returns the next entry, false if there are no more entries. rnd must not be shared across goroutines.
+func (p *entryPicker) next(rnd *rand.Rand) (loadspec.Entry, bool) {
+	if p.random {
+		return p.entries[rnd.Intn(len(p.entries))], true
+	}
+	i := atomic.AddInt64(&p.i, 1) - 1
+	if p.once && i >= int64(len(p.entries)) {
+		return loadspec.Entry{}, false
+	}
+	return p.entries[i%int64(len(p.entries))], true
+}
+
+type thinkTime interface {
+	Next() time.Duration
+}
+
+// newThinkTime parses the think time definition. Empty definition means no think time.
+func newThinkTime(def string, rnd *rand.Rand) (thinkTime, error) {
+	if def == "" {
+		return constThinkTime(0), nil
+	}
+	p := strings.Split(def, ":")
+	durations := make([]time.Duration, len(p)-1)
+	for i := range durations {
+		d, err := time.ParseDuration(p[i+1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid think time definition:%q", err)
+		}
+		if d < 0 {
+			return nil, fmt.Errorf("invalid think time definition:%s", def)
+		}
+		durations[i] = d
+	}
+	switch {
+	case p[0] == "const" && len(durations) == 1:
+		return constThinkTime(durations[0]), nil
+	case p[0] == "exp" && len(durations) == 1:
+		return &expThinkTime{mean: durations[0], rnd: rnd}, nil
+	case p[0] == "uniform" && len(durations) == 2 && durations[0] <= durations[1]:
+		return &uniformThinkTime{min: durations[0], max: durations[1], rnd: rnd}, nil
+	default:
+		return nil, fmt.Errorf("invalid think time definition:%s", def)
+	}
+}
+
+type constThinkTime time.Duration
+
+func (t constThinkTime) Next() time.Duration {
+	return time.Duration(t)
+}
+
+// Think times following the exponential distribution.
+type expThinkTime struct {
+	mean time.Duration
+	rnd  *rand.Rand
+}
+
+func (t *expThinkTime) Next() time.Duration {
+	return time.Duration(-math.Log(1.0-t.rnd.Float64()) * float64(t.mean))
+}
+
+// Think times uniformly distributed in [min, max].
+type uniformThinkTime struct {
+	min, max time.Duration
+	rnd      *rand.Rand
+}
+
+func (t *uniformThinkTime) Next() time.Duration {
+	return t.min + time.Duration(t.rnd.Int63n(int64(t.max-t.min)+1))
+}
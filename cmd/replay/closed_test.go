@@ -0,0 +1,128 @@
+package replay
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/danielfireman/esperf/loadspec"
+)
+
+func TestNewThinkTime(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	t.Run("Valid", func(t *testing.T) {
+		for def, check := range map[string]func(time.Duration) bool{
+			"":                    func(d time.Duration) bool { return d == 0 },
+			"const:1s":            func(d time.Duration) bool { return d == time.Second },
+			"exp:500ms":           func(d time.Duration) bool { return d >= 0 },
+			"uniform:100ms:200ms": func(d time.Duration) bool { return d >= 100*time.Millisecond && d <= 200*time.Millisecond },
+			"uniform:100ms:100ms": func(d time.Duration) bool { return d == 100*time.Millisecond },
+		} {
+			tt, err := newThinkTime(def, rnd)
+			if err != nil {
+				t.Fatalf("%s: error got:%q want:nil", def, err)
+			}
+			for i := 0; i < 100; i++ {
+				if d := tt.Next(); !check(d) {
+					t.Fatalf("%s: unexpected think time:%v", def, d)
+				}
+			}
+		}
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		for _, def := range []string{"const", "const:foo", "exp:1s:2s", "uniform:2s:1s", "poisson:1s", "const:-1s"} {
+			if _, err := newThinkTime(def, rnd); err == nil {
+				t.Fatalf("%s: error got:nil want:error", def)
+			}
+		}
+	})
+}
+
+func TestEntryPicker(t *testing.T) {
+	entries := []loadspec.Entry{{ID: 0}, {ID: 1}}
+	t.Run("SequentialOnce", func(t *testing.T) {
+		p := &entryPicker{entries: entries, once: true}
+		for i := 0; i < 2; i++ {
+			e, ok := p.next(nil)
+			if !ok || e.ID != i {
+				t.Fatalf("got:%d,%v want:%d,true", e.ID, ok, i)
+			}
+		}
+		if _, ok := p.next(nil); ok {
+			t.Fatalf("got:true want:false")
+		}
+	})
+	t.Run("SequentialWrapsAround", func(t *testing.T) {
+		p := &entryPicker{entries: entries}
+		for i := 0; i < 5; i++ {
+			e, ok := p.next(nil)
+			if !ok || e.ID != i%2 {
+				t.Fatalf("got:%d,%v want:%d,true", e.ID, ok, i%2)
+			}
+		}
+	})
+}
+
+func TestRunClosed(t *testing.T) {
+	var inFlight, maxInFlight, total int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			m := atomic.LoadInt32(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+				break
+			}
+		}
+		atomic.AddInt32(&total, 1)
+		time.Sleep(5 * time.Millisecond)
+		w.Write([]byte(`{"took":1}`))
+	}))
+	defer server.Close()
+
+	defer restoreGlobals(&numUsers, &thinkTimeDef, &order, &duration)()
+	numUsers, thinkTimeDef, order, duration = 3, "", sequentialOrder, 0
+	r := newTestRun(t, numUsers)
+	defer r.close()
+	book := make([]loadspec.Entry, 30)
+	for i := range book {
+		book[i] = loadspec.Entry{ID: i, URL: server.URL, DelaySinceLastNanos: int64(time.Hour)}
+	}
+	if err := r.runClosed(book, make(chan os.Signal)); err != nil {
+		t.Fatalf("error got:%q want:nil", err)
+	}
+	if total != 30 {
+		t.Fatalf("requests got:%d want:30", total)
+	}
+	if maxInFlight > 3 {
+		t.Fatalf("max concurrent requests got:%d want:<=3", maxInFlight)
+	}
+}
+
+func TestRunClosed_InterruptDuringPause(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	defer restoreGlobals(&numUsers, &thinkTimeDef, &order, &duration, &backpressure, &drainTimeout)()
+	numUsers, thinkTimeDef, order, duration, backpressure, drainTimeout = 2, "", sequentialOrder, time.Hour, pauseDelayPolicy, time.Hour
+	r := newTestRun(t, numUsers)
+	defer r.close()
+	book := []loadspec.Entry{{ID: 0, URL: server.URL}}
+	sig := make(chan os.Signal, 1)
+	time.AfterFunc(50*time.Millisecond, func() { sig <- os.Interrupt })
+	start := time.Now()
+	if err := r.runClosed(book, sig); err != nil {
+		t.Fatalf("error got:%q want:nil", err)
+	}
+	// The hour-long pause does not hold the interrupted load test.
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("elapsed got:%v want:<5s", elapsed)
+	}
+}
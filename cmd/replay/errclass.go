@@ -0,0 +1,123 @@
+package replay
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/danielfireman/esperf/metrics"
+)
+
+// Error classes, i.e. why a request did not succeed.
+const (
+	// The request could not be sent or the response could not be read.
+	transportError = "transport"
+	// The request did not complete before its deadline.
+	timeoutError = "timeout"
+	// 4xx responses, other than 429.
+	clientError = "client"
+	// 5xx responses, other than 503, and other unexpected status codes.
+	serverError = "server"
+	// 429 and 503 responses, i.e. the search thread pool queue is full.
+	rejectedError = "rejected"
+	// Requests still rejected after --retry_max retries. Their last response is also counted as rejected.
+	exhaustedError = "retries_exhausted"
+	// 200 responses with shard failures.
+	partialError = "partial"
+)
+
+var errorClasses = []string{transportError, timeoutError, clientError, serverError, rejectedError, exhaustedError, partialError}
+
+// esError is the error reported by Elasticsearch.
+type esError struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// decodeError decodes the error of an Elasticsearch response body. Old versions report the error as a
+// string, which becomes the reason.
+func decodeError(body io.Reader) (esError, error) {
+	resp := struct {
+		Error json.RawMessage `json:"error"`
+	}{}
+	if err := json.NewDecoder(body).Decode(&resp); err != nil {
+		return esError{}, err
+	}
+	var e esError
+	if err := json.Unmarshal(resp.Error, &e); err != nil {
+		var reason string
+		if json.Unmarshal(resp.Error, &reason) == nil {
+			e.Reason = reason
+		}
+	}
+	return e, nil
+}
+
+// errorCounters counts errors per class and client errors per Elasticsearch error type. It is safe for
+// concurrent use.
+type errorCounters struct {
+	classes map[string]*metrics.Counter
+
+	mu          sync.Mutex
+	clientTypes map[string]int64
+}
+
+// newErrorCounters returns counters of all error classes. Timeouts and rejections are counted by the passed-in
+// counters, which might be shared.
+func newErrorCounters(timeouts, rejected *metrics.Counter) *errorCounters {
+	c := &errorCounters{
+		classes:     make(map[string]*metrics.Counter),
+		clientTypes: make(map[string]int64),
+	}
+	for _, class := range errorClasses {
+		c.classes[class] = metrics.NewCounter()
+	}
+	c.classes[timeoutError] = timeouts
+	c.classes[rejectedError] = rejected
+	return c
+}
+
+// inc counts an error of the class. The Elasticsearch error type is only kept for client errors.
+func (c *errorCounters) inc(class, esType string) {
+	c.classes[class].Inc()
+	if class != clientError {
+		return
+	}
+	if esType == "" {
+		esType = "unknown"
+	}
+	c.mu.Lock()
+	c.clientTypes[esType]++
+	c.mu.Unlock()
+}
+
+// byClass returns the number of errors of each class.
+func (c *errorCounters) byClass() map[string]int64 {
+	m := make(map[string]int64, len(c.classes))
+	for class, counter := range c.classes {
+		m[class] = counter.Get()
+	}
+	return m
+}
+
+// clientErrorTypes returns the number of client errors of each Elasticsearch error type.
+func (c *errorCounters) clientErrorTypes() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := make(map[string]int64, len(c.clientTypes))
+	for t, n := range c.clientTypes {
+		m[t] = n
+	}
+	return m
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
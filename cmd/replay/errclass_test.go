@@ -0,0 +1,167 @@
+package replay
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/danielfireman/esperf/loadspec"
+)
+
+func TestDecodeError(t *testing.T) {
+	testCases := []struct {
+		body string
+		want esError
+	}{
+		{`{"error":{"root_cause":[],"type":"index_not_found_exception","reason":"no such index"},"status":404}`, esError{Type: "index_not_found_exception", Reason: "no such index"}},
+		{`{"error":"IndexMissingException[[wiki] missing]","status":404}`, esError{Reason: "IndexMissingException[[wiki] missing]"}},
+		{`{}`, esError{}},
+	}
+	for _, tc := range testCases {
+		got, err := decodeError(strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatalf("%s error got:%q want:nil", tc.body, err)
+		}
+		if got != tc.want {
+			t.Fatalf("%s got:%+v want:%+v", tc.body, got, tc.want)
+		}
+	}
+	if _, err := decodeError(strings.NewReader("<html>")); err == nil {
+		t.Fatalf("error got:nil want:error")
+	}
+}
+
+func TestRunOpen_ErrorClasses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/missing/_search":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"type":"index_not_found_exception","reason":"no such index [missing]"},"status":404}`))
+		case "/bad/_search":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"type":"parsing_exception","reason":"unknown query [foo]"},"status":400}`))
+		case "/broken/_search":
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error":{"type":"search_phase_execution_exception","reason":"all shards failed"},"status":500}`))
+		case "/busy/_search":
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error":{"type":"es_rejected_execution_exception","reason":"rejected execution"},"status":429}`))
+		case "/partial/_search":
+			w.Write([]byte(`{"took":1,"_shards":{"total":2,"successful":1,"failed":1,"failures":[{"shard":0,"reason":{"type":"node_disconnected_exception","reason":"node left"}}]}}`))
+		default:
+			w.Write([]byte(`{"took":1,"_shards":{"total":2,"successful":2,"failed":0}}`))
+		}
+	}))
+	defer server.Close()
+
+	r := newTestRun(t, 1)
+	defer r.close()
+
+	defer restoreGlobals(&continueOn400, &backpressure)()
+	continueOn400, backpressure = true, ignorePolicy
+	book := []loadspec.Entry{
+		{ID: 0, URL: server.URL + "/index/_search"},
+		{ID: 1, URL: server.URL + "/missing/_search"},
+		{ID: 2, URL: server.URL + "/bad/_search"},
+		{ID: 3, URL: server.URL + "/missing/_search"},
+		{ID: 4, URL: server.URL + "/broken/_search"},
+		{ID: 5, URL: server.URL + "/busy/_search"},
+		{ID: 6, URL: server.URL + "/partial/_search"},
+		// Nothing listens on port 1.
+		{ID: 7, URL: "http://127.0.0.1:1/index/_search"},
+	}
+	if err := r.runOpen(book, make(chan os.Signal)); err != nil {
+		t.Fatalf("error got:%q want:nil", err)
+	}
+	want := map[string]int64{transportError: 1, timeoutError: 0, clientError: 3, serverError: 1, rejectedError: 1, exhaustedError: 0, partialError: 1}
+	if got := r.errorCounters.byClass(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got:%v want:%v", got, want)
+	}
+	wantTypes := map[string]int64{"index_not_found_exception": 2, "parsing_exception": 1}
+	if got := r.errorCounters.clientErrorTypes(); !reflect.DeepEqual(got, wantTypes) {
+		t.Fatalf("got:%v want:%v", got, wantTypes)
+	}
+	if got := r.errors.Get(); got != 5 {
+		t.Fatalf("errors got:%d want:5", got)
+	}
+	b := r.rows()
+	for _, row := range []string{
+		",1,error,index_not_found_exception,no such index [missing],",
+		",4,error,search_phase_execution_exception,all shards failed,",
+		",5,throttled,es_rejected_execution_exception,rejected execution,",
+		",6,partial,node_disconnected_exception,node left,2,1,false,false,",
+		",7,error,transport,",
+	} {
+		if !strings.Contains(b, row) {
+			t.Fatalf("row %q not found in:\n%s", row, b)
+		}
+	}
+}
+
+func TestSearchResponse_Partial(t *testing.T) {
+	testCases := []struct {
+		body    string
+		want    esError
+		partial bool
+	}{
+		{`{"took":1,"timed_out":false,"_shards":{"total":5,"successful":5,"skipped":0,"failed":0}}`, esError{}, false},
+		{`{"took":1,"_shards":{"total":5,"successful":3,"failed":2,"failures":[{"reason":{"type":"query_shard_exception","reason":"failed to create query"}}]}}`, esError{Type: "query_shard_exception", Reason: "failed to create query"}, true},
+		{`{"took":1,"_shards":{"total":5,"successful":3,"failed":2}}`, esError{Reason: "2 of 5 shards failed"}, true},
+		{`{"took":1,"timed_out":true,"_shards":{"total":5,"successful":5,"failed":0}}`, esError{Reason: "search timed out"}, true},
+		{`{"took":1,"terminated_early":true,"_shards":{"total":5,"successful":5,"failed":0}}`, esError{Reason: "search terminated early"}, true},
+	}
+	for _, tc := range testCases {
+		var resp searchResponse
+		if err := json.Unmarshal([]byte(tc.body), &resp); err != nil {
+			t.Fatalf("%s error got:%q want:nil", tc.body, err)
+		}
+		got, partial := resp.partial()
+		if got != tc.want || partial != tc.partial {
+			t.Fatalf("%s got:%+v,%v want:%+v,%v", tc.body, got, partial, tc.want, tc.partial)
+		}
+	}
+}
+
+func TestRunOpen_PartialAsError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow/_search" {
+			w.Write([]byte(`{"took":900,"timed_out":true,"_shards":{"total":1,"successful":1,"failed":0}}`))
+			return
+		}
+		w.Write([]byte(`{"took":1,"timed_out":false,"_shards":{"total":1,"successful":1,"failed":0}}`))
+	}))
+	defer server.Close()
+
+	defer restoreGlobals(&partialAsErr)()
+	for _, asErr := range []bool{false, true} {
+		r := newTestRun(t, 1)
+		partialAsErr = asErr
+		book := []loadspec.Entry{{ID: 0, URL: server.URL + "/index/_search"}, {ID: 1, URL: server.URL + "/slow/_search"}}
+		err := r.runOpen(book, make(chan os.Signal))
+		r.close()
+		if err != nil {
+			t.Fatalf("error got:%q want:nil", err)
+		}
+		if got := r.errorCounters.classes[partialError].Get(); got != 1 {
+			t.Fatalf("partial_as_error:%v partial got:%d want:1", asErr, got)
+		}
+		wantErrors, wantCompleted := int64(0), int64(2)
+		if asErr {
+			wantErrors, wantCompleted = 1, 1
+		}
+		if got := r.errors.Get(); got != wantErrors {
+			t.Fatalf("partial_as_error:%v errors got:%d want:%d", asErr, got, wantErrors)
+		}
+		if got := r.completed.Get(); got != wantCompleted {
+			t.Fatalf("partial_as_error:%v completed got:%d want:%d", asErr, got, wantCompleted)
+		}
+		// Partial results are kept out of response times if they are errors.
+		if got := r.responseTimes.Snapshot().Count(); got != wantCompleted {
+			t.Fatalf("partial_as_error:%v response times got:%d want:%d", asErr, got, wantCompleted)
+		}
+	}
+}
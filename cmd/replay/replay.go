@@ -1,27 +1,27 @@
 package replay
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"net"
+	"io/ioutil"
 	"net/http"
-	"net/http/httputil"
 	"os"
 	"os/signal"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/danielfireman/esperf/esmetrics"
+	"github.com/danielfireman/esperf/internal/esclient"
 	"github.com/danielfireman/esperf/loadspec"
 	"github.com/danielfireman/esperf/metrics"
 	"github.com/danielfireman/esperf/reporter"
+	"github.com/danielfireman/esperf/slo"
 	"github.com/spf13/cobra"
 )
 
@@ -59,9 +59,23 @@ var (
 	cint          time.Duration
 	timeout       time.Duration
 	debug         bool
+	gzipRequests  bool
 	numClients    int
-	isPaused      int32
 	continueOn400 bool
+	partialAsErr  bool
+	mode          string
+	numUsers      int
+	thinkTimeDef  string
+	order         string
+	duration      time.Duration
+	seed          int64
+	sloDefs       []string
+	sloWindow     time.Duration
+	sloSustain    time.Duration
+	drainTimeout  time.Duration
+	targetURLs    []string
+	sniffNodes    bool
+	balance       string
 	// Adding Content-Type:application/json as default.
 	// https://www.elastic.co/blog/strict-content-type-checking-for-elasticsearch-rest-requests
 	headers = headersFlag{http.Header{"Content-Type": []string{"application/json"}}}
@@ -74,18 +88,33 @@ func init() {
 	RootCmd.Flags().StringVar(&expID, "exp_id", "1", "")
 	RootCmd.Flags().DurationVar(&timeout, "timeout", 30*time.Second, "Timeout to be used in connections to ES.")
 	RootCmd.Flags().BoolVar(&debug, "debug", false, "Dump requests and responses.")
+	RootCmd.Flags().BoolVar(&gzipRequests, "gzip", false, "Compress request bodies. Compressed responses are always decompressed.")
 	RootCmd.Flags().IntVarP(&numClients, "num_clients", "c", 10, "Number of active clients making requests.")
 	RootCmd.Flags().BoolVar(&continueOn400, "continue_on_400s", false, "Whether the loadtest should continue if it receives a 400 response.")
+	RootCmd.Flags().BoolVar(&partialAsErr, "partial_as_error", false, "Whether 200 responses with partial results (shard failures, timed out or terminated early) count as errors.")
+	RootCmd.Flags().StringVar(&mode, "mode", openLoopMode, "Workload model: open (entries are fired following the loadspec schedule) or closed (virtual users issue a request, wait for the response and think before issuing the next one).")
+	RootCmd.Flags().IntVar(&numUsers, "num_users", 10, "Number of virtual users of the closed mode.")
+	RootCmd.Flags().StringVar(&thinkTimeDef, "think_time", "", "Think time distribution of the closed mode: const:<duration>, exp:<mean duration> or uniform:<min duration>:<max duration> (i.e. exp:500ms). No think time if empty.")
+	RootCmd.Flags().StringVar(&order, "order", sequentialOrder, "Order virtual users pick loadspec entries in the closed mode: sequential or random.")
+	RootCmd.Flags().DurationVar(&duration, "duration", 0, "Duration of the closed mode run. Zero means until all entries are issued once, only valid for sequential order.")
+	RootCmd.Flags().Int64Var(&seed, "seed", 0, "Seed of the random number generator used by the closed mode. Zero means a time-based seed.")
+	RootCmd.Flags().StringSliceVar(&sloDefs, "slo", []string{}, "Thresholds the load test must meet, i.e. p99<200ms, error_rate<1% or cpu<90 (requires --mon_host). Latency percentiles (p50, p90, p99 and p999) refer to the response time (took). The flag can be repeated.")
+	RootCmd.Flags().DurationVar(&sloWindow, "slo_window", 30*time.Second, "Sliding window thresholds are evaluated over while running. Zero means the whole run so far.")
+	RootCmd.Flags().DurationVar(&sloSustain, "slo_sustain", 10*time.Second, "The load test is aborted if a threshold is continuously breached for this long.")
+	RootCmd.Flags().DurationVar(&drainTimeout, "drain_timeout", 30*time.Second, "How long an interrupted (SIGINT or SIGTERM) load test waits for in-flight requests before cancelling them.")
+	addBackpressureFlags(RootCmd)
+	RootCmd.Flags().StringSliceVar(&targetURLs, "targets", []string{}, "Elasticsearch nodes (i.e. http://node1:9200,http://node2:9200) requests are spread across, replacing the scheme and host of loadspec entries. Empty means entries URLs are used as they are.")
+	RootCmd.Flags().BoolVar(&sniffNodes, "sniff", false, "Whether targets are all HTTP enabled nodes of the cluster, sniffed from --targets (or --mon_host) using the nodes info API.")
+	RootCmd.Flags().StringVar(&balance, "balance", roundRobinBalance, "How requests are spread across targets: round_robin, random or least_in_flight.")
 	RootCmd.Flags().VarP(&headers, "headers", "H", "Custom HTTP headers. You can specify as many as needed by repeating the flag. \"Content-Type: application/json\" is added by default.")
 }
 
 var (
-	// DefaultLocalAddr is the default local IP address an Attacker uses.
-	defaultLocalAddr = net.IPAddr{IP: net.IPv4zero}
 	// DefaultConnections is the default amount of max open idle connections per
 	// target host.
 	defaultConnections = 10000
-	r                  runner
+	r                  *runner
+	thresholds         []slo.Threshold
 )
 
 var RootCmd = &cobra.Command{
@@ -96,49 +125,63 @@ var RootCmd = &cobra.Command{
 		if numClients < 1 {
 			return fmt.Errorf("number of clients must be positive")
 		}
-
+		if err := checkBackpressureFlags(); err != nil {
+			return err
+		}
 		var err error
-		r = runner{}
-		if resultsPath == "" {
-			return fmt.Errorf("results path can not be empty. Please set --results_path flag")
+		if thresholds, err = parseThresholds(sloDefs, host); err != nil {
+			return err
 		}
-
-		r.requestsSent = metrics.NewCounter()
-		r.errors = metrics.NewCounter()
-		r.responseTimes = metrics.NewHistogram()
-		r.pauseTimes = metrics.NewHistogram()
-		r.clients = make(chan *http.Client, numClients)
-		for i := 0; i < numClients; i++ {
-			r.clients <- &http.Client{
-				Transport: &http.Transport{
-					Dial: (&net.Dialer{
-						LocalAddr: &net.TCPAddr{IP: defaultLocalAddr.IP, Zone: defaultLocalAddr.Zone},
-						KeepAlive: 3 * timeout,
-						Timeout:   timeout,
-					}).Dial,
-					ResponseHeaderTimeout: timeout,
-					MaxIdleConnsPerHost:   defaultConnections,
-				},
+		poolSize := numClients
+		switch mode {
+		case openLoopMode:
+		case closedLoopMode:
+			if err := checkClosedLoopFlags(); err != nil {
+				return err
 			}
+			// Each virtual user has its own client.
+			poolSize = numUsers
+		default:
+			return fmt.Errorf("invalid mode:%q", mode)
 		}
 
-		r.perRequest, err = reporter.NewPerRequestReport(csvFilePath("request", expID, resultsPath))
+		if resultsPath == "" {
+			return fmt.Errorf("results path can not be empty. Please set --results_path flag")
+		}
+		r, err = newRunner(poolSize, csvFilePath("request", expID, resultsPath))
 		if err != nil {
 			return err
 		}
 
 		// TODO(danielfireman): Review metrics collection design.
-		collector, err := esmetrics.NewCollector(host, timeout, debug)
+		collector, err := esmetrics.NewCollector(host, timeout, debug, esclient.Default)
+		if err != nil {
+			return err
+		}
+		if host != "" {
+			r.cpu = collector.CPU
+		}
+		sniffer, err := esclient.New(esclient.Default, esclient.Options{Timeout: timeout, Debug: debug})
+		if err != nil {
+			return err
+		}
+		r.balancer, err = newTargetBalancer(sniffer)
 		if err != nil {
 			return err
 		}
-		r.report, err = reporter.New(
-			cint,
-			timeout,
+		opts := []reporter.ReportOption{
 			reporter.MetricToCSV(r.responseTimes, csvFilePath("response.time", expID, resultsPath)),
 			reporter.MetricToCSV(r.pauseTimes, csvFilePath("pause.time", expID, resultsPath)),
 			reporter.MetricToCSV(r.requestsSent, csvFilePath("requests.sent", expID, resultsPath)),
 			reporter.MetricToCSV(r.errors, csvFilePath("errors", expID, resultsPath)),
+			reporter.MetricToCSV(r.timeouts, csvFilePath("timeouts", expID, resultsPath)),
+			reporter.MetricToCSV(r.throttled, csvFilePath("throttled", expID, resultsPath)),
+			reporter.MetricToCSV(r.retries, csvFilePath("retries", expID, resultsPath)),
+			reporter.MetricToCSV(r.dropped, csvFilePath("dropped", expID, resultsPath)),
+			reporter.MetricToCSV(r.assertionFailures, csvFilePath("assertion.failures", expID, resultsPath)),
+			reporter.MetricToCSV(r.hitsTotals, csvFilePath("hits.total", expID, resultsPath)),
+			reporter.MetricToCSV(r.requestSizes, csvFilePath("request.bytes", expID, resultsPath)),
+			reporter.MetricToCSV(r.responseSizes, csvFilePath("response.bytes", expID, resultsPath)),
 			reporter.AddCollector(collector),
 			reporter.MetricToCSV(collector.Mem.YoungHeapPool, csvFilePath("mem.young", expID, resultsPath)),
 			reporter.MetricToCSV(collector.Mem.TenuredHeapPool, csvFilePath("mem.tenured", expID, resultsPath)),
@@ -150,7 +193,20 @@ var RootCmd = &cobra.Command{
 			reporter.MetricToCSV(collector.CPU, csvFilePath("cpu", expID, resultsPath)),
 			reporter.MetricToCSV(collector.GC.Young, csvFilePath("gc.young", expID, resultsPath)),
 			reporter.MetricToCSV(collector.GC.Full, csvFilePath("gc.full", expID, resultsPath)),
-		)
+		}
+		for _, class := range errorClasses {
+			opts = append(opts, reporter.MetricToCSV(r.errorCounters.classes[class], csvFilePath("errors."+class, expID, resultsPath)))
+		}
+		if r.balancer != nil {
+			for _, t := range r.balancer.targets {
+				opts = append(opts,
+					reporter.MetricToCSV(t.requests, csvFilePath("target."+t.name()+".requests.sent", expID, resultsPath)),
+					reporter.MetricToCSV(t.errors, csvFilePath("target."+t.name()+".errors", expID, resultsPath)),
+					reporter.MetricToCSV(t.latencies, csvFilePath("target."+t.name()+".latency", expID, resultsPath)),
+				)
+			}
+		}
+		r.report, err = reporter.New(cint, timeout, opts...)
 		if err != nil {
 			return err
 		}
@@ -165,51 +221,307 @@ var RootCmd = &cobra.Command{
 }
 
 type runner struct {
-	clients chan *http.Client
-	report  *reporter.Reporter
+	clients chan *esclient.Client
+	// All clients, free or not.
+	pool   []*esclient.Client
+	report *reporter.Reporter
 
 	requestsSent  *metrics.Counter
 	responseTimes *metrics.Histogram
 	errors        *metrics.Counter
 	pauseTimes    *metrics.Histogram
 	perRequest    *reporter.PerRequestReport
+	// Successful requests, requests which did not complete before their deadline, requests answered with 429
+	// or 503, retries and entries dropped during pauses. Timeouts are not counted as errors.
+	completed *metrics.Counter
+	timeouts  *metrics.Counter
+	throttled *metrics.Counter
+	retries   *metrics.Counter
+	dropped   *metrics.Counter
+	// Errors per class. Timeouts and rejections are the timeouts and throttled counters.
+	errorCounters *errorCounters
+	// Responses which did not pass the assertions of their entries, and the report of what did not match
+	// (nil if not reported).
+	assertionFailures *metrics.Counter
+	mismatches        *reporter.MismatchReport
+	// Hits of successful searches, and request and response body sizes in bytes.
+	hitsTotals    *metrics.Histogram
+	requestSizes  *metrics.Histogram
+	responseSizes *metrics.Histogram
+
+	// Spreads requests across targets, nil if entries URLs are used as they are.
+	balancer *balancer
+
+	// Service level objectives evaluation, nil if there are no thresholds.
+	slo *slo.Evaluator
+	// CPU usage of the monitored host, nil if there is no monitoring.
+	cpu *metrics.IntGaugeSet
+
+	// Cancelled when the load test fails, which also cancels outstanding requests.
+	ctx     context.Context
+	cancel  context.CancelFunc
+	errOnce sync.Once
+	err     error
+
+	// Signal which interrupted the load test, nil if it was not interrupted.
+	interruptedBy os.Signal
+	// Closed when a threshold is breached for too long, which stops dispatching. In-flight requests are drained.
+	stop      chan struct{}
+	abortOnce sync.Once
+	// Threshold which aborted the load test, empty if it was not aborted.
+	abortedBy string
+	// Accessed atomically.
+	// 1 while dispatching is paused by a 429 or 503 response, so only one pause is sent at a time.
+	paused      int32
+	inFlight    int64
+	dispatched  int64
+	lastEntryID int64
+	// Number of in-flight requests cancelled after the drain timeout.
+	abandoned int64
+}
+
+// Error is returned when the load test stops because a request failed unrecoverably, i.e. a 4xx
+// response without --continue_on_400s.
+type Error struct {
+	// ID of the loadspec entry which caused the failure.
+	EntryID int
+	Err     error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("load test stopped, entry %d: %v", e.EntryID, e.Err)
 }
 
 func csvFilePath(name, expID, resultsPath string) string {
 	return filepath.Join(resultsPath, name+"_"+expID+".csv")
 }
 
+// newRunner returns a runner with poolSize clients, fresh metrics and a per-request report written to
+// perRequestPath. Reporting metrics and collectors is up to the caller.
+func newRunner(poolSize int, perRequestPath string) (*runner, error) {
+	perRequest, err := reporter.NewPerRequestReport(perRequestPath)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &runner{
+		ctx:               ctx,
+		cancel:            cancel,
+		stop:              make(chan struct{}),
+		lastEntryID:       -1,
+		requestsSent:      metrics.NewCounter(),
+		errors:            metrics.NewCounter(),
+		responseTimes:     metrics.NewHistogram(),
+		pauseTimes:        metrics.NewHistogram(),
+		completed:         metrics.NewCounter(),
+		timeouts:          metrics.NewCounter(),
+		throttled:         metrics.NewCounter(),
+		retries:           metrics.NewCounter(),
+		dropped:           metrics.NewCounter(),
+		assertionFailures: metrics.NewCounter(),
+		hitsTotals:        metrics.NewHistogram(),
+		requestSizes:      metrics.NewHistogram(),
+		responseSizes:     metrics.NewHistogram(),
+		perRequest:        perRequest,
+		clients:           make(chan *esclient.Client, poolSize),
+	}
+	r.errorCounters = newErrorCounters(r.timeouts, r.throttled)
+	for i := 0; i < poolSize; i++ {
+		client, err := esclient.New(esclient.Default, esclient.Options{
+			Timeout:             timeout,
+			MaxIdleConnsPerHost: defaultConnections,
+			Debug:               debug,
+			Gzip:                gzipRequests,
+		})
+		if err != nil {
+			return nil, err
+		}
+		r.clients <- client
+		r.pool = append(r.pool, client)
+	}
+	return r, nil
+}
+
+// closeIdleConnections closes the idle connections of all clients.
+func (r *runner) closeIdleConnections() {
+	for _, client := range r.pool {
+		client.CloseIdleConnections()
+	}
+}
+
+// fail stops the load test because of err. Only the first failure is kept.
+func (r *runner) fail(entry loadspec.Entry, err error) {
+	r.errOnce.Do(func() {
+		r.err = &Error{EntryID: entry.ID, Err: err}
+		r.cancel()
+	})
+}
+
+// failed returns the error which stopped the load test, nil if it has not failed. It must only be called
+// after all requests have finished.
+func (r *runner) failed() error {
+	return r.err
+}
+
 func (r *runner) Run() error {
 	r.report.Start()
 	defer r.report.Finish()
 	r.perRequest.Start()
 	defer r.perRequest.Finish()
 
-	var wg sync.WaitGroup
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt, os.Kill)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
 
 	// Loading the whole load in memory upfront. This avoid glitches due to disk being slow during high load
 	// replays.
-	var replayBook []loadspec.Entry
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		entry := loadspec.Entry{}
-		if err := json.NewDecoder(strings.NewReader(scanner.Text())).Decode(&entry); err != nil {
+	header, replayBook, err := loadspec.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	// Keeping track of how the loadspec was made, along with the results.
+	if header != nil {
+		if err := writeHeader(header, filepath.Join(resultsPath, "loadspec.header_"+expID+".json")); err != nil {
 			return err
 		}
-		replayBook = append(replayBook, entry)
 	}
+	for _, e := range replayBook {
+		if e.Assert != nil {
+			if r.mismatches, err = reporter.NewMismatchReport(csvFilePath("assertions", expID, resultsPath)); err != nil {
+				return err
+			}
+			r.mismatches.Start()
+			defer r.mismatches.Finish()
+			break
+		}
+	}
+
+	start := time.Now()
+	if len(thresholds) == 0 {
+		err = r.runMode(replayBook, sig)
+	} else {
+		err = r.runSLO(replayBook, sig, start)
+	}
+	if sErr := writeSummary(r.summary(len(replayBook), time.Since(start), err), filepath.Join(resultsPath, "summary_"+expID+".json")); sErr != nil && err == nil {
+		return sErr
+	}
+	return err
+}
+
+// parseThresholds parses the --slo definitions. CPU thresholds need a monitored host, otherwise there would be
+// no data and they would always pass.
+func parseThresholds(defs []string, monHost string) ([]slo.Threshold, error) {
+	var thresholds []slo.Threshold
+	for _, def := range defs {
+		t, err := slo.Parse(def)
+		if err != nil {
+			return nil, err
+		}
+		if t.Metric == slo.CPU && monHost == "" {
+			return nil, fmt.Errorf("threshold %s requires --mon_host", t.Expr)
+		}
+		thresholds = append(thresholds, t)
+	}
+	return thresholds, nil
+}
 
+// runSLO runs the load test evaluating the thresholds, aborting it if any of them is breached for too long.
+func (r *runner) runSLO(replayBook []loadspec.Entry, sig <-chan os.Signal, start time.Time) error {
+	r.slo = slo.NewEvaluator(thresholds, sloWindow, sloSustain, start)
+	done := make(chan struct{})
+	evalDone := make(chan struct{})
+	go func() {
+		defer close(evalDone)
+		ticker := time.NewTicker(cint)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if t := r.slo.Evaluate(r.observe(now)); t != nil {
+					fmt.Printf("Threshold %s breached for %v, aborting load test.\n", t.Expr, sloSustain)
+					r.abort(t.Expr)
+					return
+				}
+			}
+		}
+	}()
+	runErr := r.runMode(replayBook, sig)
+	close(done)
+	<-evalDone
+
+	// The verdict is written even if the load test did not run to the end.
+	verdict := r.slo.Verdict(r.observe(time.Now()))
+	switch {
+	case runErr != nil:
+		verdict.Incomplete, verdict.StoppedBy = true, runErr.Error()
+	case r.interruptedBy != nil:
+		verdict.Incomplete, verdict.StoppedBy = true, r.interruptedBy.String()
+	}
+	buf, err := json.MarshalIndent(verdict, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(buf))
+	if err := ioutil.WriteFile(filepath.Join(resultsPath, "verdict_"+expID+".json"), buf, 0666); err != nil {
+		return err
+	}
+	if runErr != nil {
+		return runErr
+	}
+	if !verdict.Pass {
+		return &slo.Error{Verdict: verdict}
+	}
+	return nil
+}
+
+func (r *runner) runMode(replayBook []loadspec.Entry, sig <-chan os.Signal) error {
+	switch mode {
+	case closedLoopMode:
+		return r.runClosed(replayBook, sig)
+	default:
+		return r.runOpen(replayBook, sig)
+	}
+}
+
+// outcomes returns how many requests the load test made and how many of them did not succeed. Entries dropped
+// during pauses were shed by the cluster as much as rejected requests, so they count as failed requests.
+// Timeouts and rejections (429 and 503) count as failures too, exhausted retries are already rejections.
+func (r *runner) outcomes() (requests, failed int64) {
+	dropped := r.dropped.Get()
+	requests = r.requestsSent.Get() + dropped
+	failed = r.errors.Get() - r.errorCounters.classes[exhaustedError].Get() + r.timeouts.Get() + r.throttled.Get() + dropped
+	return requests, failed
+}
+
+// observe returns the current state of the load test, to be evaluated against the thresholds.
+func (r *runner) observe(now time.Time) slo.Observation {
+	o := slo.Observation{Time: now}
+	o.Requests, o.Errors = r.outcomes()
+	if r.cpu != nil {
+		// CPU time is zero until the first successful collection.
+		if v := r.cpu.Get(); v[1] > 0 {
+			o.CPU, o.HasCPU = float64(v[0]), true
+		}
+	}
+	return o
+}
+
+// runOpen fires the entries following the loadspec schedule.
+func (r *runner) runOpen(replayBook []loadspec.Entry, sig <-chan os.Signal) error {
+	var wg sync.WaitGroup
 	// Note: Having a single worker or a single load generator is a way to guarantee the load will obey to a
 	// certain  distribution. For instance, 10 workers generating load following a Poisson distribution is
 	// different from having Poisson ruling the overall load impressed on the service.
-	// Note 2: Dropping requests made during pauses.
+	// Note 2: Dropping requests made during pauses, unless the schedule is shifted instead (pause_delay).
 	pauseTime := int64(0)
-	pauseChan := make(chan time.Duration)
+	pauseChan := make(chan time.Duration, 1)
 	for _, entry := range replayBook {
 		if pauseTime > 0 {
 			pauseTime -= entry.DelaySinceLastNanos
+			r.dropped.Inc()
+			r.perRequest.RequestProcessed(reporter.Request{TS: time.Now().Unix(), ID: entry.ID, Status: reporter.StatusDropped})
 			continue
 		} else {
 			pauseTime = 0
@@ -218,153 +530,319 @@ func (r *runner) Run() error {
 		start := time.Now()
 
 		// Pretty simple thread-safe pool implementation.
-		client := <-r.clients
+		var client *esclient.Client
+		select {
+		case client = <-r.clients:
+		case s := <-sig:
+			r.interrupt(s, sig)
+			return r.drainOpen(&wg, pauseChan)
+		case <-r.stop:
+			return r.drainOpen(&wg, pauseChan)
+		case <-r.ctx.Done():
+			return r.drainOpen(&wg, pauseChan)
+		}
 
 		// Taking into account the time waiting for a free client.
 		delay := entry.DelaySinceLastNanos - (time.Now().Sub(start)).Nanoseconds()
-		if delay > 0 {
-			time.Sleep(time.Duration(delay))
+		if delay > 0 && !r.sleep(time.Duration(delay), sig) {
+			r.clients <- client
+			return r.drainOpen(&wg, pauseChan)
 		}
 
 		wg.Add(1)
-		go func(entry loadspec.Entry, client *http.Client) {
+		go func(entry loadspec.Entry, client *esclient.Client) {
 			defer wg.Done()
 			defer func() {
 				r.clients <- client
 			}()
-
-			startRequest := time.Now()
-			req, err := newRequest(entry.URL, entry.Source)
-			if err != nil {
-				// TODO(danielfireman): Make this more elegant. Leveraging cobra error messages.
-				fmt.Printf("Error creating request: %q\n", err)
-				os.Exit(-1)
-				return
-			}
-
-			if debug {
-				dReq, _ := httputil.DumpRequest(req, true)
-				fmt.Println(string(dReq))
-			}
-
-			r.requestsSent.Inc()
-			ctx, cancel := context.WithTimeout(context.Background(), timeout)
-			defer cancel()
-			req.WithContext(ctx)
-
-			resp, err := client.Do(req)
-			if err != nil {
-				r.errors.Inc()
-				fmt.Printf("Error sending request: %q\n", err)
-				return
-			}
-			latency := time.Now().Sub(startRequest).Nanoseconds() / int64(1000)
-
-			if debug {
-				dResp, _ := httputil.DumpResponse(resp, true)
-				fmt.Println(string(dResp))
-			}
-
-			defer resp.Body.Close()
-			code := resp.StatusCode
-			switch {
-			default:
-				r.errors.Inc()
-				r.perRequest.RequestProcessed(time.Now().Unix(), resp.StatusCode, 0, 0, entry.ID)
-			case code == http.StatusOK:
-				searchResp := struct {
-					TookInMillis int64 `json:"took"`
-				}{}
-				if err := json.NewDecoder(resp.Body).Decode(&searchResp); err != nil {
-					fmt.Printf("error parsing response: %q\n", err)
-					// TODO(danielfireman): Make this more elegant. Leveraging cobra error messages.
-					os.Exit(-1)
-					return
-				}
-				r.responseTimes.Record(searchResp.TookInMillis)
-				r.perRequest.RequestProcessed(time.Now().Unix(), resp.StatusCode, searchResp.TookInMillis, latency, entry.ID)
-			case code >= 400 && code < 500:
-				r.perRequest.RequestProcessed(time.Now().Unix(), resp.StatusCode, 0, 0, entry.ID)
-				searchResp := struct {
-					Error struct {
-						Type   string `json:"type"`
-						Reason string `json:"reason"`
-					} `json:"error"`
-				}{}
-				if err := json.NewDecoder(resp.Body).Decode(&searchResp); err != nil {
-					fmt.Printf("error parsing bad request response: %q\n", err)
-					// TODO(danielfireman): Make this more elegant. Leveraging cobra error messages.
-					os.Exit(-1)
-					return
-				}
-				if !continueOn400 {
-					dReq, _ := httputil.DumpRequest(req, true)
-					fmt.Printf("error querying server:\nReq:%s\n Error:%+v\n", string(dReq), searchResp.Error)
-					// TODO(danielfireman): Make this more elegant. Leveraging cobra error messages.
-					os.Exit(-1)
-				}
-				r.errors.Inc()
-			case code == http.StatusServiceUnavailable || code == http.StatusTooManyRequests:
-				r.perRequest.RequestProcessed(time.Now().Unix(), resp.StatusCode, 0, 0, entry.ID)
-				if atomic.LoadInt32(&isPaused) == 1 {
-					return
-				}
-				ra := resp.Header.Get("Retry-After")
-				if ra == "" {
-					// TODO(danielfireman): Make this more elegant. Leveraging cobra error messages.
-					fmt.Println("Could not extract retry-after information")
-					os.Exit(-1)
-				}
-				pt, err := strconv.ParseFloat(ra, 64)
-				if err != nil {
-					// TODO(danielfireman): Make this more elegant. Leveraging cobra error messages.
-					fmt.Println("Could not extract retry-after information")
-					os.Exit(-1)
-				}
-				pauseMillis := int64(pt * 1e3)
-				r.pauseTimes.Record(pauseMillis)
-				// If the loadtest is paused, ignore this signal.
-				if atomic.LoadInt32(&isPaused) == 1 {
-					return
-				}
-				// Only enqueue if the pause queue is empty.
-				if len(pauseChan) == 0 {
-					atomic.StoreInt32(&isPaused, 1)
-					pauseChan <- time.Duration(pauseMillis) * time.Millisecond
-				}
-			}
+			r.fire(client, entry, pauseChan)
 		}(entry, client)
 
 		// Non-blocking check of pauses.
 		select {
 		case pt := <-pauseChan:
-			pauseTime = pt.Nanoseconds()
-			time.Sleep(pt)
-			atomic.StoreInt32(&isPaused, 0)
-		case <-sig:
-			fmt.Println("Interrupting load test.")
-			return nil
+			if backpressure == pauseDropPolicy {
+				pauseTime = pt.Nanoseconds()
+			}
+			if !r.sleep(pt, sig) {
+				return r.drainOpen(&wg, pauseChan)
+			}
+			atomic.StoreInt32(&r.paused, 0)
+		case s := <-sig:
+			r.interrupt(s, sig)
+			return r.drainOpen(&wg, pauseChan)
+		case <-r.stop:
+			return r.drainOpen(&wg, pauseChan)
+		case <-r.ctx.Done():
+			return r.drainOpen(&wg, pauseChan)
 		default:
 		}
 	}
-	if err := scanner.Err(); err != nil {
-		return err
+	return r.drainOpen(&wg, pauseChan)
+}
+
+// sleep pauses dispatching for d. It returns false if the load test was interrupted, aborted or failed
+// meanwhile.
+func (r *runner) sleep(d time.Duration, sig <-chan os.Signal) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case s := <-sig:
+		r.interrupt(s, sig)
+		return false
+	case <-r.stop:
+		return false
+	case <-r.ctx.Done():
+		return false
 	}
+}
+
+// drainOpen waits for outstanding requests and returns the error which stopped the load test, if any.
+func (r *runner) drainOpen(wg *sync.WaitGroup, pauseChan chan time.Duration) error {
+	done := make(chan struct{})
 	go func() {
 		wg.Wait()
-		close(pauseChan)
+		close(done)
 	}()
 	// Avoiding any goroutine to be blocked on adding to the pause channel
-	for range pauseChan {
+	go func() {
+		for range pauseChan {
+		}
+	}()
+	r.wait(done)
+	close(pauseChan)
+	return r.failed()
+}
+
+// fire sends the request described by entry and records its outcome. Throttled requests (429 and 503
+// responses) are handled following the back-pressure policy, pauses are sent to pauseChan. Unrecoverable
+// failures stop the load test.
+func (r *runner) fire(client *esclient.Client, entry loadspec.Entry, pauseChan chan<- time.Duration) {
+	if r.ctx.Err() != nil {
+		// The load test has already failed.
+		return
+	}
+	atomic.AddInt64(&r.inFlight, 1)
+	defer atomic.AddInt64(&r.inFlight, -1)
+	atomic.AddInt64(&r.dispatched, 1)
+	atomic.StoreInt64(&r.lastEntryID, int64(entry.ID))
+	for attempt := 0; ; attempt++ {
+		wait, retry := r.send(client, entry, attempt, pauseChan)
+		if !retry {
+			return
+		}
+		r.retries.Inc()
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-r.ctx.Done():
+			timer.Stop()
+			return
+		}
 	}
-	return nil
 }
 
-func newRequest(url, source string) (*http.Request, error) {
-	req, err := http.NewRequest("GET", url, strings.NewReader(source))
+// send makes the attempt-th try (starting at zero) of sending the request described by entry. It returns
+// whether the request must be retried, according to the back-pressure policy, and how long to wait before.
+func (r *runner) send(client *esclient.Client, entry loadspec.Entry, attempt int, pauseChan chan<- time.Duration) (time.Duration, bool) {
+	startRequest := time.Now()
+	u := entry.URL
+	var t *target
+	if r.balancer != nil {
+		t = r.balancer.pick()
+		var err error
+		if u, err = t.rewrite(u); err != nil {
+			r.fail(entry, fmt.Errorf("error creating request: %q", err))
+			return 0, false
+		}
+	}
+	ctx, cancel := context.WithTimeout(r.ctx, requestTimeout(entry))
+	defer cancel()
+	req, err := newRequest(ctx, entry.Method, u, entry.Source)
 	if err != nil {
-		return nil, err
+		r.fail(entry, fmt.Errorf("error creating request: %q", err))
+		return 0, false
+	}
+
+	r.requestsSent.Inc()
+	r.requestSizes.Record(int64(len(entry.Source)))
+
+	if t != nil {
+		t.requests.Inc()
+		t.inFlight.Inc()
+		defer t.inFlight.Dec()
 	}
-	req.Header = headers.Header
-	return req, nil
+	resp, err := client.Do(req)
+	if t != nil {
+		if err != nil || resp.StatusCode >= 400 {
+			t.errors.Inc()
+		}
+		if err == nil {
+			t.latencies.Record(time.Since(startRequest).Nanoseconds() / int64(time.Millisecond))
+		}
+	}
+	if err != nil {
+		if r.ctx.Err() != nil {
+			// Cancelled because the load test has failed or could not drain in time.
+			return 0, false
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			r.timedOut(entry, 0, 0)
+			return 0, false
+		}
+		r.requestFailed(entry, transportError, 0, 0, 0, esError{Reason: err.Error()})
+		fmt.Printf("Error sending request: %q\n", err)
+		return 0, false
+	}
+	latency := time.Now().Sub(startRequest).Nanoseconds() / int64(1000)
+
+	code := resp.StatusCode
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		switch {
+		case r.ctx.Err() != nil:
+		case ctx.Err() == context.DeadlineExceeded:
+			r.timedOut(entry, code, latency)
+		default:
+			r.requestFailed(entry, transportError, code, latency, 0, esError{Reason: err.Error()})
+		}
+		return 0, false
+	}
+	r.responseSizes.Record(int64(len(body)))
+	if entry.Assert != nil {
+		r.check(entry, code, body)
+	}
+	switch {
+	default:
+		class := serverError
+		if code < 500 {
+			class = clientError
+		}
+		// Best effort, the body might not come from Elasticsearch (i.e. a proxy).
+		e, _ := decodeError(bytes.NewReader(body))
+		r.requestFailed(entry, class, code, latency, len(body), e)
+	case code == http.StatusOK:
+		var searchResp searchResponse
+		if err := json.Unmarshal(body, &searchResp); err != nil {
+			r.fail(entry, fmt.Errorf("error parsing response: %q", err))
+			return 0, false
+		}
+		req := reporter.Request{
+			TS:              time.Now().Unix(),
+			Code:            code,
+			TookInMillis:    searchResp.TookInMillis,
+			LatencyMicros:   latency,
+			ID:              entry.ID,
+			Status:          reporter.StatusOK,
+			ShardsTotal:     searchResp.Shards.Total,
+			ShardsFailed:    searchResp.Shards.Failed,
+			TimedOut:        searchResp.TimedOut,
+			TerminatedEarly: searchResp.TerminatedEarly,
+			RequestBytes:    len(entry.Source),
+			ResponseBytes:   len(body),
+		}
+		if total := searchResp.Hits.Total; total != nil {
+			req.HitsTotal, req.HitsRelation = total.Value, total.Relation
+			r.hitsTotals.Record(total.Value)
+		}
+		if e, ok := searchResp.partial(); ok {
+			r.errorCounters.inc(partialError, e.Type)
+			req.Status, req.ErrorType, req.ErrorReason = reporter.StatusPartial, errorType(partialError, e), e.Reason
+			if partialAsErr {
+				// Partial results are cheaper, keeping them out of response times.
+				r.errors.Inc()
+				req.Status = reporter.StatusError
+				r.perRequest.RequestProcessed(req)
+				return 0, false
+			}
+		}
+		r.responseTimes.Record(searchResp.TookInMillis)
+		if r.slo != nil {
+			r.slo.RecordLatency(float64(searchResp.TookInMillis))
+		}
+		r.completed.Inc()
+		r.perRequest.RequestProcessed(req)
+	// Must come before other 4xx responses.
+	case code == http.StatusServiceUnavailable || code == http.StatusTooManyRequests:
+		e, _ := decodeError(bytes.NewReader(body))
+		r.perRequest.RequestProcessed(reporter.Request{TS: time.Now().Unix(), Code: code, LatencyMicros: latency, ID: entry.ID, Status: reporter.StatusThrottled, ErrorType: errorType(rejectedError, e), ErrorReason: e.Reason, RequestBytes: len(entry.Source), ResponseBytes: len(body)})
+		return r.onThrottled(resp, attempt, pauseChan)
+	case code >= 400 && code < 500:
+		e, err := decodeError(bytes.NewReader(body))
+		if err != nil {
+			r.requestFailed(entry, clientError, code, latency, len(body), esError{})
+			r.fail(entry, fmt.Errorf("error parsing bad request response: %q", err))
+			return 0, false
+		}
+		r.requestFailed(entry, clientError, code, latency, len(body), e)
+		if !continueOn400 {
+			r.fail(entry, fmt.Errorf("error querying server: status:%d type:%s reason:%s", code, e.Type, e.Reason))
+			return 0, false
+		}
+	}
+	return 0, false
+}
+
+// check evaluates the entry assertions against the response to it.
+func (r *runner) check(entry loadspec.Entry, code int, body []byte) {
+	mm := evaluate(entry.Assert, code, body)
+	if len(mm) == 0 {
+		return
+	}
+	r.assertionFailures.Inc()
+	if r.mismatches == nil {
+		return
+	}
+	now := time.Now().Unix()
+	for _, m := range mm {
+		r.mismatches.Mismatched(reporter.Mismatch{TS: now, ID: entry.ID, Code: code, Assertion: m.assertion, Expected: m.expected, Actual: m.actual})
+	}
+}
+
+// requestTimeout returns the deadline of the request described by entry: its own timeout or --timeout.
+func requestTimeout(entry loadspec.Entry) time.Duration {
+	if entry.TimeoutNanos > 0 {
+		return time.Duration(entry.TimeoutNanos)
+	}
+	return timeout
+}
+
+// timedOut records a request which did not complete before its deadline. Code and latency are zero if the
+// response headers were not received.
+func (r *runner) timedOut(entry loadspec.Entry, code int, latency int64) {
+	r.errorCounters.inc(timeoutError, "")
+	reason := fmt.Sprintf("deadline of %v exceeded", requestTimeout(entry))
+	r.perRequest.RequestProcessed(reporter.Request{TS: time.Now().Unix(), Code: code, LatencyMicros: latency, ID: entry.ID, Status: reporter.StatusTimeout, ErrorType: timeoutError, ErrorReason: reason, RequestBytes: len(entry.Source)})
+	fmt.Printf("Request timed out, entry:%d %s\n", entry.ID, reason)
+}
+
+// requestFailed records a request which failed because of an error of the class. Code, latency and response size
+// are zero if there was no response.
+func (r *runner) requestFailed(entry loadspec.Entry, class string, code int, latency int64, responseBytes int, e esError) {
+	r.errors.Inc()
+	r.errorCounters.inc(class, e.Type)
+	r.perRequest.RequestProcessed(reporter.Request{TS: time.Now().Unix(), Code: code, LatencyMicros: latency, ID: entry.ID, Status: reporter.StatusError, ErrorType: errorType(class, e), ErrorReason: e.Reason, RequestBytes: len(entry.Source), ResponseBytes: responseBytes})
+}
+
+// errorType returns the Elasticsearch error type or, if there is none, the error class.
+func errorType(class string, e esError) string {
+	if e.Type != "" {
+		return e.Type
+	}
+	return class
+}
+
+func writeHeader(h *loadspec.Header, path string) error {
+	buf, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf, 0666)
+}
+
+func newRequest(ctx context.Context, method, url, source string) (*http.Request, error) {
+	return esclient.NewRequest(ctx, method, url, source, headers.Header)
 }
@@ -1,17 +1,31 @@
 package replay
 
 import (
+	"context"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/danielfireman/esperf/loadspec"
 )
 
 func TestNewRequest(t *testing.T) {
 	t.Run("ValidRequest", func(t *testing.T) {
-		req, err := newRequest("url", "source")
+		req, err := newRequest(context.Background(), "", "url", "source")
 		if err != nil {
 			t.Fatalf("error got:%q want:nil", err)
 		}
+		if req.Method != "GET" {
+			t.Fatalf("got:%s want:GET", req.Method)
+		}
 		if req.URL.String() != "url" {
 			t.Fatalf("got:%s want:url", req.URL.String())
 		}
@@ -24,8 +38,18 @@ func TestNewRequest(t *testing.T) {
 		}
 	})
 
+	t.Run("CustomMethod", func(t *testing.T) {
+		req, err := newRequest(context.Background(), "POST", "url", "source")
+		if err != nil {
+			t.Fatalf("error got:%q want:nil", err)
+		}
+		if req.Method != "POST" {
+			t.Fatalf("got:%s want:POST", req.Method)
+		}
+	})
+
 	t.Run("InvalidRequest", func(t *testing.T) {
-		_, err := newRequest("%zzzzz", "source")
+		_, err := newRequest(context.Background(), "", "%zzzzz", "source")
 		if err == nil {
 			t.Fatalf("error got:nil want:error")
 		}
@@ -50,3 +74,202 @@ func TestHeadersFlag_Set(t *testing.T) {
 		}
 	})
 }
+
+func TestRunOpen_StopsOnFailure(t *testing.T) {
+	var total int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(&total, 1)
+		if n == 3 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"type":"parsing_exception","reason":"unknown query"}}`))
+			return
+		}
+		w.Write([]byte(`{"took":1}`))
+	}))
+	defer server.Close()
+
+	r := newTestRun(t, 1)
+	defer r.close()
+
+	defer restoreGlobals(&continueOn400)()
+	continueOn400 = false
+	book := make([]loadspec.Entry, 10)
+	for i := range book {
+		book[i] = loadspec.Entry{ID: i, URL: server.URL, DelaySinceLastNanos: int64(time.Millisecond)}
+	}
+	err := r.runOpen(book, make(chan os.Signal))
+	e, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("error got:%v want:*Error", err)
+	}
+	if e.EntryID != 2 {
+		t.Fatalf("entry got:%d want:2", e.EntryID)
+	}
+	if total != 3 {
+		t.Fatalf("requests got:%d want:3", total)
+	}
+	// The per-request report is flushed.
+	b := r.rows()
+	if lines := strings.Count(b, "\n"); lines != 4 {
+		t.Fatalf("lines got:%d want:4\n%s", lines, b)
+	}
+}
+
+func TestRunOpen_Timeouts(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow_body" {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			<-release
+			return
+		}
+		if req.URL.Path == "/slow" {
+			<-release
+		}
+		w.Write([]byte(`{"took":1}`))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	r := newTestRun(t, 3)
+	defer r.close()
+
+	defer restoreGlobals(&timeout)()
+	timeout = time.Minute
+	book := []loadspec.Entry{
+		{ID: 0, URL: server.URL + "/fast"},
+		// The deadline covers reading the response body.
+		{ID: 1, URL: server.URL + "/slow_body", TimeoutNanos: int64(50 * time.Millisecond)},
+		{ID: 2, URL: server.URL + "/slow", TimeoutNanos: int64(50 * time.Millisecond)},
+	}
+	err := r.runOpen(book, make(chan os.Signal))
+	if err != nil {
+		t.Fatalf("error got:%q want:nil", err)
+	}
+	if got := r.timeouts.Get(); got != 2 {
+		t.Fatalf("timeouts got:%d want:2", got)
+	}
+	if got := r.errors.Get(); got != 0 {
+		t.Fatalf("errors got:%d want:0", got)
+	}
+	if got := r.completed.Get(); got != 1 {
+		t.Fatalf("completed got:%d want:1", got)
+	}
+	b := r.rows()
+	if n := strings.Count(b, ",timeout,"); n != 2 {
+		t.Fatalf("timeout rows got:%d want:2\n%s", n, b)
+	}
+}
+
+func TestRunOpen_HitsAndSizes(t *testing.T) {
+	es6 := `{"took":1,"hits":{"total":3,"hits":[]}}`
+	es7 := `{"took":1,"hits":{"total":{"value":10000,"relation":"gte"},"hits":[]}}`
+	untracked := `{"took":1,"hits":{"hits":[]}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/es6":
+			w.Write([]byte(es6))
+		case "/es7":
+			w.Write([]byte(es7))
+		default:
+			w.Write([]byte(untracked))
+		}
+	}))
+	defer server.Close()
+
+	r := newTestRun(t, 1)
+	defer r.close()
+
+	source := `{"query":{"match_all":{}}}`
+	book := []loadspec.Entry{
+		{ID: 0, URL: server.URL + "/es6", Source: source},
+		{ID: 1, URL: server.URL + "/es7", Source: source},
+		{ID: 2, URL: server.URL + "/untracked"},
+	}
+	err := r.runOpen(book, make(chan os.Signal))
+	if err != nil {
+		t.Fatalf("error got:%q want:nil", err)
+	}
+	if got := r.hitsTotals.Snapshot().Count(); got != 2 {
+		t.Fatalf("hits totals got:%d want:2", got)
+	}
+	if got := r.requestSizes.Snapshot().Count(); got != 3 {
+		t.Fatalf("request sizes got:%d want:3", got)
+	}
+	if got := r.responseSizes.Snapshot().Count(); got != 3 {
+		t.Fatalf("response sizes got:%d want:3", got)
+	}
+	b := r.rows()
+	for _, suffix := range []string{
+		fmt.Sprintf(",3,eq,%d,%d\n", len(source), len(es6)),
+		fmt.Sprintf(",10000,gte,%d,%d\n", len(source), len(es7)),
+		fmt.Sprintf(",,,0,%d\n", len(untracked)),
+	} {
+		if !strings.Contains(b, suffix) {
+			t.Fatalf("row ending with %q not found in:\n%s", suffix, b)
+		}
+	}
+}
+
+// testRun is a runner whose per-request report goes to a temporary directory.
+type testRun struct {
+	*runner
+	t        *testing.T
+	dir      string
+	finished bool
+}
+
+// newTestRun creates a runner with poolSize clients and starts its per-request report. Callers must defer close.
+func newTestRun(t *testing.T, poolSize int) *testRun {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatalf("error got:%q want:nil", err)
+	}
+	r, err := newRunner(poolSize, filepath.Join(dir, "request.csv"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("error got:%q want:nil", err)
+	}
+	r.perRequest.Start()
+	return &testRun{runner: r, t: t, dir: dir}
+}
+
+// rows flushes the per-request report and returns its content.
+func (r *testRun) rows() string {
+	r.finish()
+	b, err := ioutil.ReadFile(filepath.Join(r.dir, "request.csv"))
+	if err != nil {
+		r.t.Fatalf("error got:%q want:nil", err)
+	}
+	return string(b)
+}
+
+// finish flushes the per-request report, which can only be done once.
+func (r *testRun) finish() {
+	if !r.finished {
+		r.finished = true
+		r.perRequest.Finish()
+	}
+}
+
+func (r *testRun) close() {
+	r.finish()
+	os.RemoveAll(r.dir)
+}
+
+// restoreGlobals saves the values the pointers point to and returns a function setting them back, to be
+// deferred by tests that change package flags: defer restoreGlobals(&timeout)().
+func restoreGlobals(ptrs ...interface{}) func() {
+	saved := make([]reflect.Value, len(ptrs))
+	for i, p := range ptrs {
+		v := reflect.ValueOf(p).Elem()
+		saved[i] = reflect.New(v.Type()).Elem()
+		saved[i].Set(v)
+	}
+	return func() {
+		for i, p := range ptrs {
+			reflect.ValueOf(p).Elem().Set(saved[i])
+		}
+	}
+}
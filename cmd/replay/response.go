@@ -0,0 +1,68 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// searchResponse is the part of search responses replay looks at.
+type searchResponse struct {
+	TookInMillis    int64 `json:"took"`
+	TimedOut        bool  `json:"timed_out"`
+	TerminatedEarly bool  `json:"terminated_early"`
+	Shards          struct {
+		Total      int `json:"total"`
+		Successful int `json:"successful"`
+		Skipped    int `json:"skipped"`
+		Failed     int `json:"failed"`
+		Failures   []struct {
+			Reason esError `json:"reason"`
+		} `json:"failures"`
+	} `json:"_shards"`
+	Hits struct {
+		// Nil if the total is not tracked.
+		Total *hitsTotal `json:"total"`
+	} `json:"hits"`
+}
+
+// hitsTotal is the number of hits matching the query. Elasticsearch 7 onwards might only count up to a limit,
+// telling whether the value is exact (eq) or a lower bound (gte).
+type hitsTotal struct {
+	Value    int64  `json:"value"`
+	Relation string `json:"relation"`
+}
+
+// UnmarshalJSON accepts both the number (Elasticsearch 6 and before) and object forms.
+func (h *hitsTotal) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &h.Value); err == nil {
+		h.Relation = "eq"
+		return nil
+	}
+	type object hitsTotal
+	return json.Unmarshal(b, (*object)(h))
+}
+
+func (h *hitsTotal) String() string {
+	if h.Relation == "gte" {
+		return fmt.Sprintf(">=%d", h.Value)
+	}
+	return fmt.Sprintf("%d", h.Value)
+}
+
+// partial returns whether results are partial, i.e. some shards failed, the search timed out or it terminated
+// early, and why.
+func (s *searchResponse) partial() (esError, bool) {
+	switch {
+	case s.Shards.Failed > 0:
+		if len(s.Shards.Failures) > 0 {
+			// Failures are often alike, the first one is enough.
+			return s.Shards.Failures[0].Reason, true
+		}
+		return esError{Reason: fmt.Sprintf("%d of %d shards failed", s.Shards.Failed, s.Shards.Total)}, true
+	case s.TimedOut:
+		return esError{Reason: "search timed out"}, true
+	case s.TerminatedEarly:
+		return esError{Reason: "search terminated early"}, true
+	}
+	return esError{}, false
+}
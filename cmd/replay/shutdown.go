@@ -0,0 +1,151 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Exit code used when a second signal forces the exit.
+const forcedExitCode = 130
+
+// interrupt stops dispatching because of s. A second signal received from sig forces the exit, without
+// draining or flushing anything.
+func (r *runner) interrupt(s os.Signal, sig <-chan os.Signal) {
+	r.interruptedBy = s
+	fmt.Printf("Interrupting load test, waiting up to %v for in-flight requests. Send the signal again to force exit.\n", drainTimeout)
+	go func() {
+		if _, ok := <-sig; ok {
+			fmt.Println("Forcing exit.")
+			os.Exit(forcedExitCode)
+		}
+	}()
+}
+
+// abort stops dispatching because the threshold expr was breached for too long. Unlike interruptions,
+// in-flight requests are waited for without a drain timeout.
+func (r *runner) abort(expr string) {
+	r.abortOnce.Do(func() {
+		r.abortedBy = expr
+		close(r.stop)
+	})
+}
+
+// wait waits for done to be closed. Interrupted load tests wait up to --drain_timeout, then outstanding
+// requests are cancelled.
+func (r *runner) wait(done <-chan struct{}) {
+	if r.interruptedBy == nil {
+		<-done
+		return
+	}
+	timer := time.NewTimer(drainTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		r.abandoned = atomic.LoadInt64(&r.inFlight)
+		fmt.Printf("Drain timeout, cancelling %d in-flight requests.\n", r.abandoned)
+		r.cancel()
+		<-done
+	}
+}
+
+// summary describes how the load test ended, reconciling loadspec entries against requests.
+type summary struct {
+	Interrupted bool   `json:"interrupted"`
+	Signal      string `json:"signal,omitempty"`
+	// Threshold which aborted the load test, empty if it was not aborted.
+	AbortedBySLO string `json:"aborted_by_slo,omitempty"`
+	// ID of the last entry dispatched, -1 if none.
+	LastEntryID int64 `json:"last_entry_id"`
+	// Entries in the loadspec, which were either dispatched, dropped during pauses or not dispatched at all
+	// (i.e. the load test was interrupted). Closed-loop runs with a duration might dispatch entries more than once.
+	Entries              int   `json:"entries"`
+	EntriesDispatched    int64 `json:"entries_dispatched"`
+	EntriesDropped       int64 `json:"entries_dropped"`
+	EntriesNotDispatched int64 `json:"entries_not_dispatched"`
+	// Requests sent, including retries.
+	Requests          int64  `json:"requests"`
+	Completed         int64  `json:"completed"`
+	Errors            int64  `json:"errors"`
+	Timeouts          int64  `json:"timeouts"`
+	Partial           int64  `json:"partial"`
+	Throttled         int64  `json:"throttled"`
+	Retries           int64  `json:"retries"`
+	RequestsAbandoned int64  `json:"requests_abandoned"`
+	Duration          string `json:"duration"`
+	Error             string `json:"error,omitempty"`
+	// Responses which did not pass the assertions of their entries.
+	AssertionFailures int64 `json:"assertion_failures"`
+	// Requests which did not succeed, per error class, and client errors per Elasticsearch error type.
+	ErrorsByClass    map[string]int64 `json:"errors_by_class"`
+	ClientErrorTypes map[string]int64 `json:"client_errors_by_type,omitempty"`
+}
+
+func (r *runner) summary(entries int, elapsed time.Duration, err error) summary {
+	s := summary{
+		Interrupted:       r.interruptedBy != nil,
+		AbortedBySLO:      r.abortedBy,
+		LastEntryID:       atomic.LoadInt64(&r.lastEntryID),
+		Entries:           entries,
+		EntriesDispatched: atomic.LoadInt64(&r.dispatched),
+		EntriesDropped:    r.dropped.Get(),
+		Requests:          r.requestsSent.Get(),
+		Completed:         r.completed.Get(),
+		Errors:            r.errors.Get(),
+		Timeouts:          r.timeouts.Get(),
+		Partial:           r.errorCounters.classes[partialError].Get(),
+		ErrorsByClass:     r.errorCounters.byClass(),
+		ClientErrorTypes:  r.errorCounters.clientErrorTypes(),
+		Throttled:         r.throttled.Get(),
+		Retries:           r.retries.Get(),
+		AssertionFailures: r.assertionFailures.Get(),
+		RequestsAbandoned: r.abandoned,
+		Duration:          elapsed.String(),
+	}
+	if n := int64(entries) - s.EntriesDispatched - s.EntriesDropped; n > 0 {
+		s.EntriesNotDispatched = n
+	}
+	if r.interruptedBy != nil {
+		s.Signal = r.interruptedBy.String()
+	}
+	if err != nil {
+		s.Error = err.Error()
+	}
+	return s
+}
+
+func writeSummary(s summary, path string) error {
+	if s.Interrupted {
+		fmt.Printf("Load test interrupted by %s at entry %d (%d of %d entries dispatched, %d in-flight requests abandoned).\n", s.Signal, s.LastEntryID, s.EntriesDispatched, s.Entries, s.RequestsAbandoned)
+	}
+	if s.AbortedBySLO != "" {
+		fmt.Printf("Load test aborted by threshold %s at entry %d (%d of %d entries dispatched).\n", s.AbortedBySLO, s.LastEntryID, s.EntriesDispatched, s.Entries)
+	}
+	fmt.Printf("Entries:%d dispatched:%d dropped:%d not dispatched:%d\n", s.Entries, s.EntriesDispatched, s.EntriesDropped, s.EntriesNotDispatched)
+	fmt.Printf("Requests:%d completed:%d errors:%d timeouts:%d partial:%d throttled:%d retries:%d\n", s.Requests, s.Completed, s.Errors, s.Timeouts, s.Partial, s.Throttled, s.Retries)
+	if s.AssertionFailures > 0 {
+		fmt.Printf("Assertion failures:%d\n", s.AssertionFailures)
+	}
+	classes := make([]string, len(errorClasses))
+	for i, class := range errorClasses {
+		classes[i] = fmt.Sprintf("%s:%d", class, s.ErrorsByClass[class])
+	}
+	fmt.Printf("Errors by class: %s\n", strings.Join(classes, " "))
+	if len(s.ClientErrorTypes) > 0 {
+		var types []string
+		for _, t := range sortedKeys(s.ClientErrorTypes) {
+			types = append(types, fmt.Sprintf("%s:%d", t, s.ClientErrorTypes[t]))
+		}
+		fmt.Printf("Client errors by type: %s\n", strings.Join(types, " "))
+	}
+	buf, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf, 0666)
+}
@@ -0,0 +1,167 @@
+package replay
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danielfireman/esperf/loadspec"
+	"github.com/danielfireman/esperf/slo"
+)
+
+func TestRunOpen_Interrupt(t *testing.T) {
+	run := func(t *testing.T, serverDelay, drain time.Duration) (*runner, error) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			select {
+			case <-time.After(serverDelay):
+			case <-req.Context().Done():
+			}
+			w.Write([]byte(`{"took":1}`))
+		}))
+		defer server.Close()
+
+		r := newTestRun(t, 2)
+		defer r.close()
+
+		defer restoreGlobals(&drainTimeout)()
+		drainTimeout = drain
+		book := make([]loadspec.Entry, 10)
+		for i := range book {
+			book[i] = loadspec.Entry{ID: i, URL: server.URL}
+		}
+		book[2].DelaySinceLastNanos = int64(time.Hour)
+		sig := make(chan os.Signal, 1)
+		time.AfterFunc(50*time.Millisecond, func() { sig <- os.Interrupt })
+		return r.runner, r.runOpen(book, sig)
+	}
+
+	t.Run("Drained", func(t *testing.T) {
+		r, err := run(t, 100*time.Millisecond, time.Minute)
+		if err != nil {
+			t.Fatalf("error got:%q want:nil", err)
+		}
+		s := r.summary(10, time.Second, err)
+		if !s.Interrupted || s.Signal != os.Interrupt.String() {
+			t.Fatalf("got:%+v want:interrupted", s)
+		}
+		if s.EntriesDispatched != 2 || s.RequestsAbandoned != 0 || s.Errors != 0 {
+			t.Fatalf("got:%+v want:2 dispatched, none abandoned", s)
+		}
+		if got := r.responseTimes.Snapshot().Count(); got != 2 {
+			t.Fatalf("responses got:%d want:2", got)
+		}
+	})
+
+	t.Run("DrainTimeout", func(t *testing.T) {
+		start := time.Now()
+		r, err := run(t, time.Hour, 10*time.Millisecond)
+		if err != nil {
+			t.Fatalf("error got:%q want:nil", err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Fatalf("elapsed got:%v want:<5s", elapsed)
+		}
+		s := r.summary(10, time.Second, err)
+		if s.RequestsAbandoned != 2 || s.Errors != 0 {
+			t.Fatalf("got:%+v want:2 abandoned", s)
+		}
+	})
+}
+
+func TestParseThresholds(t *testing.T) {
+	got, err := parseThresholds([]string{"p99<200ms", "cpu<90"}, "http://localhost:9200")
+	if err != nil || len(got) != 2 {
+		t.Fatalf("got:%v,%v want:2 thresholds", got, err)
+	}
+	// Without a monitored host there is no CPU data.
+	if _, err := parseThresholds([]string{"p99<200ms", "cpu<90"}, ""); err == nil {
+		t.Fatalf("error got:nil want:error")
+	}
+}
+
+func TestObserve(t *testing.T) {
+	r := newTestRun(t, 1)
+	defer r.close()
+	for i := 0; i < 10; i++ {
+		r.requestsSent.Inc()
+	}
+	// Rejections and dropped entries are errors, as when evaluating capacity steps.
+	r.throttled.Inc()
+	r.dropped.Inc()
+	if o := r.observe(time.Now()); o.Requests != 11 || o.Errors != 2 {
+		t.Fatalf("got:%d,%d want:11,2", o.Requests, o.Errors)
+	}
+}
+
+func TestRunSLO_Abort(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	r := newTestRun(t, 2)
+	defer r.close()
+
+	threshold, err := slo.Parse("error_rate<1%")
+	if err != nil {
+		t.Fatalf("error got:%q want:nil", err)
+	}
+	defer restoreGlobals(&thresholds, &cint, &sloWindow, &sloSustain, &resultsPath)()
+	thresholds, cint, sloWindow, sloSustain, resultsPath = []slo.Threshold{threshold}, 10*time.Millisecond, 0, 0, r.dir
+
+	book := make([]loadspec.Entry, 10)
+	for i := range book {
+		book[i] = loadspec.Entry{ID: i, URL: server.URL}
+	}
+	book[2].DelaySinceLastNanos = int64(time.Hour)
+	err = r.runSLO(book, make(chan os.Signal), time.Now())
+	sErr, ok := err.(*slo.Error)
+	if !ok || !sErr.Verdict.Aborted {
+		t.Fatalf("error got:%v want:aborted", err)
+	}
+	s := r.summary(10, time.Second, err)
+	if s.Interrupted || s.Signal != "" || s.AbortedBySLO != "error_rate<1%" {
+		t.Fatalf("got:%+v want:aborted by error_rate<1%%", s)
+	}
+	if s.EntriesDispatched != 2 || s.RequestsAbandoned != 0 {
+		t.Fatalf("got:%+v want:2 dispatched, none abandoned", s)
+	}
+}
+
+func TestRunSLO_Incomplete(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	r := newTestRun(t, 1)
+	defer r.close()
+	threshold, err := slo.Parse("p99<1s")
+	if err != nil {
+		t.Fatalf("error got:%q want:nil", err)
+	}
+	defer restoreGlobals(&thresholds, &cint, &sloWindow, &sloSustain, &resultsPath, &expID, &continueOn400)()
+	thresholds, cint, sloWindow, sloSustain, resultsPath, expID, continueOn400 = []slo.Threshold{threshold}, time.Hour, 0, 0, r.dir, "incomplete", false
+
+	// The first response stops the load test.
+	err = r.runSLO([]loadspec.Entry{{ID: 0, URL: server.URL}}, make(chan os.Signal), time.Now())
+	if _, ok := err.(*Error); !ok {
+		t.Fatalf("error got:%v want:*Error", err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(r.dir, "verdict_incomplete.json"))
+	if err != nil {
+		t.Fatalf("error got:%q want:nil", err)
+	}
+	var verdict slo.Verdict
+	if err := json.Unmarshal(b, &verdict); err != nil {
+		t.Fatalf("error got:%q want:nil", err)
+	}
+	if !verdict.Incomplete || verdict.StoppedBy == "" {
+		t.Fatalf("got:%+v want:incomplete", verdict)
+	}
+}
@@ -0,0 +1,191 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/danielfireman/esperf/internal/esclient"
+	"github.com/danielfireman/esperf/metrics"
+)
+
+// Balancing policies, i.e. how requests are spread across targets.
+const (
+	roundRobinBalance    = "round_robin"
+	randomBalance        = "random"
+	leastInFlightBalance = "least_in_flight"
+)
+
+// target is an Elasticsearch node requests are sent to.
+type target struct {
+	scheme string
+	host   string
+
+	inFlight *metrics.Counter
+	requests *metrics.Counter
+	errors   *metrics.Counter
+	// Latency seen by the client, in milliseconds.
+	latencies *metrics.Histogram
+}
+
+func newTarget(rawURL string) (*target, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid target:%q", rawURL)
+	}
+	return &target{
+		scheme:    u.Scheme,
+		host:      u.Host,
+		inFlight:  metrics.NewCounter(),
+		requests:  metrics.NewCounter(),
+		errors:    metrics.NewCounter(),
+		latencies: metrics.NewHistogram(),
+	}, nil
+}
+
+// name identifies the target in file names.
+func (t *target) name() string {
+	return strings.NewReplacer(":", "_", "[", "", "]", "").Replace(t.host)
+}
+
+// rewrite replaces the scheme and host of rawURL by the target ones.
+func (t *target) rewrite(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	u.Scheme, u.Host = t.scheme, t.host
+	return u.String(), nil
+}
+
+// balancer spreads requests across targets. It is safe for concurrent use.
+type balancer struct {
+	targets []*target
+	policy  string
+	// Next target, round robin only.
+	next uint64
+}
+
+func newBalancer(targets []*target, policy string) (*balancer, error) {
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no targets to balance")
+	}
+	switch policy {
+	case roundRobinBalance, randomBalance, leastInFlightBalance:
+	default:
+		return nil, fmt.Errorf("invalid balancing policy:%q", policy)
+	}
+	return &balancer{targets: targets, policy: policy}, nil
+}
+
+func (b *balancer) pick() *target {
+	switch b.policy {
+	case randomBalance:
+		return b.targets[rand.Intn(len(b.targets))]
+	case leastInFlightBalance:
+		// Starting at a different target each time, so ties are broken round robin.
+		start := int(atomic.AddUint64(&b.next, 1) % uint64(len(b.targets)))
+		best := b.targets[start]
+		for i := 1; i < len(b.targets); i++ {
+			t := b.targets[(start+i)%len(b.targets)]
+			if t.inFlight.Get() < best.inFlight.Get() {
+				best = t
+			}
+		}
+		return best
+	default:
+		return b.targets[(atomic.AddUint64(&b.next, 1)-1)%uint64(len(b.targets))]
+	}
+}
+
+// sniff returns the HTTP address of all nodes of the cluster seed belongs to, using the nodes info API.
+// Addresses use the seed scheme.
+func sniff(client *esclient.Client, seed string) ([]string, error) {
+	u, err := url.Parse(seed)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Get(context.Background(), u.Scheme+"://"+u.Host+"/_nodes/http")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error sniffing nodes from %s, status:%d", seed, resp.StatusCode)
+	}
+	nodesInfo := struct {
+		Nodes map[string]struct {
+			HTTP struct {
+				PublishAddress string `json:"publish_address"`
+			} `json:"http"`
+		} `json:"nodes"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&nodesInfo); err != nil {
+		return nil, fmt.Errorf("error parsing nodes info: %q", err)
+	}
+	var addrs []string
+	for _, n := range nodesInfo.Nodes {
+		addr := n.HTTP.PublishAddress
+		if addr == "" {
+			// Nodes with HTTP disabled.
+			continue
+		}
+		// Publish addresses might come as hostname/ip:port.
+		if i := strings.LastIndex(addr, "/"); i >= 0 {
+			addr = addr[i+1:]
+		}
+		addrs = append(addrs, u.Scheme+"://"+addr)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no HTTP enabled nodes found sniffing %s", seed)
+	}
+	// Map iteration order is random, keeping round robin predictable.
+	sort.Strings(addrs)
+	return addrs, nil
+}
+
+// newTargetBalancer returns the balancer of --targets, nil if there are none. If --sniff is set, targets are
+// the cluster nodes, sniffed from the first reachable seed: --targets or --mon_host.
+func newTargetBalancer(client *esclient.Client) (*balancer, error) {
+	addrs := targetURLs
+	if sniffNodes {
+		seeds := targetURLs
+		if len(seeds) == 0 && host != "" {
+			seeds = []string{host}
+		}
+		if len(seeds) == 0 {
+			return nil, fmt.Errorf("sniffing requires --targets or --mon_host")
+		}
+		var err error
+		for _, seed := range seeds {
+			if addrs, err = sniff(client, seed); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("Sniffed %d nodes: %s\n", len(addrs), strings.Join(addrs, ", "))
+	}
+	if len(addrs) == 0 {
+		return nil, nil
+	}
+	var targets []*target
+	for _, addr := range addrs {
+		t, err := newTarget(addr)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	return newBalancer(targets, balance)
+}
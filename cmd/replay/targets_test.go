@@ -0,0 +1,141 @@
+package replay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/danielfireman/esperf/internal/esclient"
+	"github.com/danielfireman/esperf/loadspec"
+)
+
+func newTestTargets(t *testing.T, urls ...string) []*target {
+	var targets []*target
+	for _, u := range urls {
+		target, err := newTarget(u)
+		if err != nil {
+			t.Fatalf("error got:%q want:nil", err)
+		}
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+func TestTarget_Rewrite(t *testing.T) {
+	target := newTestTargets(t, "https://node2:9201")[0]
+	got, err := target.rewrite("http://node1:9200/wiki/_search?q=foo")
+	if err != nil {
+		t.Fatalf("error got:%q want:nil", err)
+	}
+	if want := "https://node2:9201/wiki/_search?q=foo"; got != want {
+		t.Fatalf("got:%s want:%s", got, want)
+	}
+	if target.name() != "node2_9201" {
+		t.Fatalf("got:%s want:node2_9201", target.name())
+	}
+	if _, err := newTarget("node1:9200"); err == nil {
+		t.Fatalf("error got:nil want:error")
+	}
+}
+
+func TestBalancer(t *testing.T) {
+	t.Run("RoundRobin", func(t *testing.T) {
+		targets := newTestTargets(t, "http://a:9200", "http://b:9200", "http://c:9200")
+		b, err := newBalancer(targets, roundRobinBalance)
+		if err != nil {
+			t.Fatalf("error got:%q want:nil", err)
+		}
+		for i := 0; i < 6; i++ {
+			if got := b.pick(); got != targets[i%3] {
+				t.Fatalf("pick %d got:%s want:%s", i, got.host, targets[i%3].host)
+			}
+		}
+	})
+	t.Run("LeastInFlight", func(t *testing.T) {
+		targets := newTestTargets(t, "http://a:9200", "http://b:9200", "http://c:9200")
+		b, err := newBalancer(targets, leastInFlightBalance)
+		if err != nil {
+			t.Fatalf("error got:%q want:nil", err)
+		}
+		targets[0].inFlight.Inc()
+		targets[2].inFlight.Inc()
+		for i := 0; i < 3; i++ {
+			if got := b.pick(); got != targets[1] {
+				t.Fatalf("got:%s want:b:9200", got.host)
+			}
+		}
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		if _, err := newBalancer(newTestTargets(t, "http://a:9200"), "foo"); err == nil {
+			t.Fatalf("error got:nil want:error")
+		}
+		if _, err := newBalancer(nil, roundRobinBalance); err == nil {
+			t.Fatalf("error got:nil want:error")
+		}
+	})
+}
+
+func TestSniff(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/_nodes/http" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"nodes":{
+			"n1":{"name":"es1","http":{"publish_address":"10.0.0.2:9200"}},
+			"n2":{"name":"es2","http":{"publish_address":"es2.local/10.0.0.1:9200"}},
+			"n3":{"name":"master"}}}`))
+	}))
+	defer server.Close()
+	client, err := esclient.New(esclient.Config{}, esclient.Options{})
+	if err != nil {
+		t.Fatalf("error got:%q want:nil", err)
+	}
+	got, err := sniff(client, server.URL+"/wiki/_search")
+	if err != nil {
+		t.Fatalf("error got:%q want:nil", err)
+	}
+	want := []string{"http://10.0.0.1:9200", "http://10.0.0.2:9200"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got:%v want:%v", got, want)
+	}
+}
+
+func TestRunOpen_Targets(t *testing.T) {
+	var hits [2]int32
+	var servers []*httptest.Server
+	for i := range hits {
+		i := i
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&hits[i], 1)
+			w.Write([]byte(`{"took":1}`))
+		}))
+		defer s.Close()
+		servers = append(servers, s)
+	}
+
+	r := newTestRun(t, 1)
+	defer r.close()
+	targets := newTestTargets(t, servers[0].URL, servers[1].URL)
+	var err error
+	if r.balancer, err = newBalancer(targets, roundRobinBalance); err != nil {
+		t.Fatalf("error got:%q want:nil", err)
+	}
+
+	book := make([]loadspec.Entry, 10)
+	for i := range book {
+		// Nothing listens there.
+		book[i] = loadspec.Entry{ID: i, URL: "http://127.0.0.1:1/wiki/_search"}
+	}
+	if err := r.runOpen(book, make(chan os.Signal)); err != nil {
+		t.Fatalf("error got:%q want:nil", err)
+	}
+	for i, target := range targets {
+		if hits[i] != 5 || target.requests.Get() != 5 || target.errors.Get() != 0 || target.latencies.Snapshot().Count() != 5 {
+			t.Fatalf("target %d hits:%d requests:%d errors:%d want:5,5,0", i, hits[i], target.requests.Get(), target.errors.Get())
+		}
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"github.com/danielfireman/esperf/cmd/anonymizeindex"
 	"github.com/danielfireman/esperf/cmd/hitcounter"
 	"github.com/danielfireman/esperf/cmd/loadspec"
+	"github.com/danielfireman/esperf/cmd/record"
 	"github.com/danielfireman/esperf/cmd/replay"
+	"github.com/danielfireman/esperf/internal/esclient"
 	"github.com/spf13/cobra"
 )
 
@@ -24,8 +26,11 @@ Source code and documentation is available at http://github.com/danielfireman/es
 }
 
 func init() {
+	esclient.Default.AddFlags(RootCmd.PersistentFlags())
 	RootCmd.AddCommand(replay.RootCmd)
+	RootCmd.AddCommand(replay.CapacityCmd)
 	RootCmd.AddCommand(loadspec.RootCmd)
 	RootCmd.AddCommand(hitcounter.RootCmd)
 	RootCmd.AddCommand(anonymizeindex.RootCmd)
+	RootCmd.AddCommand(record.RootCmd)
 }
@@ -3,13 +3,10 @@ package esmetrics
 import (
 	"context"
 	"encoding/json"
-	"fmt"
-	"net"
-	"net/http"
-	"net/http/httputil"
 	"strings"
 	"time"
 
+	"github.com/danielfireman/esperf/internal/esclient"
 	"github.com/danielfireman/esperf/metrics"
 )
 
@@ -32,18 +29,19 @@ type GC struct {
 // target host.
 const defaultConnections = 10
 
-func NewCollector(host string, timeout time.Duration, debug bool) (*ESCollector, error) {
-
+// NewCollector returns a collector of host node stats. Connections are configured by conn.
+func NewCollector(host string, timeout time.Duration, debug bool, conn esclient.Config) (*ESCollector, error) {
+	client, err := esclient.New(conn, esclient.Options{
+		Timeout:             timeout,
+		MaxIdleConnsPerHost: defaultConnections,
+		Debug:               debug,
+	})
+	if err != nil {
+		return nil, err
+	}
 	return &ESCollector{
-		debug: debug,
-		url:   strings.Join([]string{host, "_nodes", "stats"}, "/"),
-		client: http.Client{
-			Transport: &http.Transport{
-				Dial: (&net.Dialer{KeepAlive: 3 * timeout, Timeout: timeout}).Dial,
-				ResponseHeaderTimeout: timeout,
-				MaxIdleConnsPerHost:   defaultConnections,
-			},
-		},
+		url:    strings.Join([]string{host, "_nodes", "stats"}, "/"),
+		client: client,
 		Mem: Mem{
 			YoungHeapPool:    metrics.NewIntGaugeSet("used", "max"),
 			TenuredHeapPool:  metrics.NewIntGaugeSet("used", "max"),
@@ -63,9 +61,8 @@ func NewCollector(host string, timeout time.Duration, debug bool) (*ESCollector,
 }
 
 type ESCollector struct {
-	debug  bool
 	url    string
-	client http.Client
+	client *esclient.Client
 	GC     GC
 	CPU    *metrics.IntGaugeSet
 	Mem    Mem
@@ -127,17 +124,12 @@ type StatsResponse struct {
 }
 
 func (c *ESCollector) Collect(ctx context.Context) error {
-	resp, err := c.client.Get(c.url)
+	resp, err := c.client.Get(ctx, c.url)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	if c.debug {
-		dResp, _ := httputil.DumpResponse(resp, true)
-		fmt.Println(string(dResp))
-	}
-
 	stats := StatsResponse{}
 	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
 		return err
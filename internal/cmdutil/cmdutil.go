@@ -0,0 +1,67 @@
+// Package cmdutil builds the loadspec metadata shared by all commands which make loadspecs, so headers are
+// the same no matter how the loadspec was made.
+package cmdutil
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"time"
+
+	"github.com/danielfireman/esperf/anon"
+	"github.com/danielfireman/esperf/internal/esclient"
+	"github.com/danielfireman/esperf/loadspec"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// NewHeader returns a loadspec header describing the command being executed. Credentials are not recorded.
+func NewHeader(cmd *cobra.Command, args []string) *loadspec.Header {
+	h := &loadspec.Header{
+		Command:   cmd.CommandPath(),
+		Flags:     make(map[string]string),
+		Args:      args,
+		CreatedAt: time.Now().UTC(),
+	}
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		h.Flags[f.Name] = esclient.FlagValue(f)
+	})
+	return h
+}
+
+// NewAnonymizer returns the anonymizer of the fields matching fields, using the dictionary at mapPath, and
+// records the dictionary SHA-256 in the header. It returns nil if mapPath is empty.
+func NewAnonymizer(mapPath string, fields []string, header *loadspec.Header) (*anon.Anonymizer, error) {
+	if mapPath == "" {
+		return nil, nil
+	}
+	fMap, err := anon.ReadFieldsMapFromFile(mapPath)
+	if err != nil {
+		return nil, err
+	}
+	fRE, err := anon.FieldsRegexpFromStringSlice(fields)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := fileSHA256(mapPath)
+	if err != nil {
+		return nil, err
+	}
+	header.AnonMapSHA256 = hash
+	return &anon.Anonymizer{FMap: fMap, FRE: fRE}, nil
+}
+
+// fileSHA256 returns the hex-encoded SHA-256 of the file contents.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,58 @@
+package cmdutil
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danielfireman/esperf/internal/esclient"
+	"github.com/danielfireman/esperf/loadspec"
+	"github.com/matryer/is"
+	"github.com/spf13/cobra"
+)
+
+func TestNewHeader(t *testing.T) {
+	is := is.New(t)
+	var conn esclient.Config
+	var factor float64
+	root := &cobra.Command{Use: "esperf"}
+	conn.AddFlags(root.PersistentFlags())
+	cmd := &cobra.Command{Use: "shift", RunE: func(*cobra.Command, []string) error { return nil }}
+	cmd.Flags().Float64Var(&factor, "factor", 1, "")
+	root.AddCommand(cmd)
+	root.SetArgs([]string{"shift", "--factor=2", "--username=elastic", "--password=s3cret", "--api_key=k3y", "--bearer_token=t0ken"})
+	is.NoErr(root.Execute())
+
+	h := NewHeader(cmd, []string{"in.json"})
+	is.Equal(h.Command, "esperf shift")
+	is.Equal(h.Args, []string{"in.json"})
+	is.Equal(h.Flags["factor"], "2")
+	is.Equal(h.Flags["api_key"], esclient.Redacted)
+	b, err := json.Marshal(h)
+	is.NoErr(err)
+	for _, secret := range []string{"elastic", "s3cret", "k3y", "t0ken"} {
+		is.True(!strings.Contains(string(b), secret)) // Credentials must not be recorded.
+	}
+}
+
+func TestNewAnonymizer(t *testing.T) {
+	is := is.New(t)
+	var h loadspec.Header
+	a, err := NewAnonymizer("", nil, &h)
+	is.NoErr(err)
+	is.True(a == nil)
+	is.Equal(h.AnonMapSHA256, "")
+
+	dir, err := ioutil.TempDir("", "cmdutil")
+	is.NoErr(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "map.json")
+	is.NoErr(ioutil.WriteFile(path, []byte(`{}`), 0666))
+	a, err = NewAnonymizer(path, []string{"name::(.*)"}, &h)
+	is.NoErr(err)
+	is.True(a != nil)
+	is.Equal(h.AnonMapSHA256, "44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a") // sha256sum of "{}"
+}
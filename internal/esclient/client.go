@@ -0,0 +1,228 @@
+package esclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"strings"
+	"time"
+)
+
+// Options of clients and transports.
+type Options struct {
+	// Deadline of whole requests, from dialing to reading the response body, unless their context already has
+	// one. Zero means no timeout.
+	Timeout time.Duration
+	// Maximum idle connections per host. Zero means the net/http default.
+	MaxIdleConnsPerHost int
+	// Dump requests and responses to STDOUT.
+	Debug bool
+	// Number of times requests which could not be sent (i.e. connection refused) are retried, waiting RetryWait
+	// between attempts. Requests answered by the server are never retried.
+	Retries   int
+	RetryWait time.Duration
+	// Compress request bodies.
+	Gzip bool
+	// Do not authenticate requests with the configured credentials, only TLS settings are used. Requests which
+	// already have an Authorization header are still sent as they are.
+	NoAuth bool
+}
+
+// NewTransport returns a transport configured by conn and opts.
+func NewTransport(conn Config, opts Options) (http.RoundTripper, error) {
+	t := &http.Transport{
+		Dial: (&net.Dialer{
+			KeepAlive: 3 * opts.Timeout,
+			Timeout:   opts.Timeout,
+		}).Dial,
+		MaxIdleConnsPerHost: opts.MaxIdleConnsPerHost,
+	}
+	if opts.NoAuth {
+		tlsConfig, err := conn.TLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		t.TLSClientConfig = tlsConfig
+		return t, nil
+	}
+	return conn.Transport(t)
+}
+
+// Client sends requests to Elasticsearch. It is safe for concurrent use.
+type Client struct {
+	http      *http.Client
+	timeout   time.Duration
+	debug     bool
+	retries   int
+	retryWait time.Duration
+	gzip      bool
+}
+
+// New returns a client configured by conn and opts.
+func New(conn Config, opts Options) (*Client, error) {
+	rt, err := NewTransport(conn, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		http:      &http.Client{Transport: rt},
+		timeout:   opts.Timeout,
+		debug:     opts.Debug,
+		retries:   opts.Retries,
+		retryWait: opts.RetryWait,
+		gzip:      opts.Gzip,
+	}, nil
+}
+
+// NewRequest returns a request bound to ctx. Method defaults to GET and header is copied.
+func NewRequest(ctx context.Context, method, url, body string, header http.Header) (*http.Request, error) {
+	if method == "" {
+		method = "GET"
+	}
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, r)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = append([]string(nil), v...)
+	}
+	return req.WithContext(ctx), nil
+}
+
+// Do sends the request, retrying if it could not be sent. Compressed responses are decompressed. Unless the
+// request context has a deadline, the client timeout applies until the response body is closed.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if _, ok := req.Context().Deadline(); !ok && c.timeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), c.timeout)
+		resp, err := c.do(req.WithContext(ctx))
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		resp.Body = &cancelBody{resp.Body, cancel}
+		return resp, nil
+	}
+	return c.do(req)
+}
+
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if c.gzip && req.Body != nil && req.Header.Get("Content-Encoding") == "" {
+		if err := compress(req); err != nil {
+			return nil, err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		if c.debug {
+			dReq, _ := httputil.DumpRequest(req, true)
+			fmt.Println(string(dReq))
+		}
+		resp, err := c.http.Do(req)
+		if err != nil {
+			if attempt >= c.retries || req.Context().Err() != nil || (req.Body != nil && req.GetBody == nil) {
+				return nil, err
+			}
+			if c.debug {
+				fmt.Fprintf(os.Stderr, "Error:[%q] Retrying:[count:%d, max:%d]\n", err, attempt+1, c.retries)
+			}
+			select {
+			case <-time.After(c.retryWait):
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			}
+			if req.GetBody != nil {
+				if req.Body, err = req.GetBody(); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if resp.Header.Get("Content-Encoding") == "gzip" {
+			// The request asked for gzip explicitly, so it was not transparently decompressed.
+			gz, err := gzip.NewReader(resp.Body)
+			if err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+			resp.Body = &gzipBody{gz, resp.Body}
+			resp.Header.Del("Content-Encoding")
+			resp.Header.Del("Content-Length")
+			resp.ContentLength = -1
+		}
+		if c.debug {
+			dResp, _ := httputil.DumpResponse(resp, true)
+			fmt.Println(string(dResp))
+		}
+		return resp, nil
+	}
+}
+
+// CloseIdleConnections closes the connections which are not carrying requests.
+func (c *Client) CloseIdleConnections() {
+	c.http.CloseIdleConnections()
+}
+
+// Get sends a GET request to url, bound to ctx.
+func (c *Client) Get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := NewRequest(ctx, "GET", url, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// compress replaces the request body by its gzip compressed version.
+func compress(req *http.Request) error {
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(body); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	compressed := buf.Bytes()
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(compressed)), nil
+	}
+	req.Body, _ = req.GetBody()
+	req.ContentLength = int64(len(compressed))
+	req.Header.Set("Content-Encoding", "gzip")
+	return nil
+}
+
+// cancelBody releases the request context once the body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (b *gzipBody) Close() error {
+	return b.body.Close()
+}
@@ -0,0 +1,175 @@
+package esclient
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestNewRequest(t *testing.T) {
+	is := is.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	header := http.Header{"Content-Type": []string{"application/json"}}
+	req, err := NewRequest(ctx, "", "http://localhost:9200/_search", `{"size":0}`, header)
+	is.NoErr(err)
+	is.Equal(req.Method, "GET")
+	is.Equal(req.Context(), ctx)
+	b, err := ioutil.ReadAll(req.Body)
+	is.NoErr(err)
+	is.Equal(string(b), `{"size":0}`)
+
+	// Headers are copied, so requests can be changed independently.
+	req.Header.Set("Content-Type", "text/plain")
+	is.Equal(header.Get("Content-Type"), "application/json")
+
+	req, err = NewRequest(ctx, "POST", "http://localhost:9200/_search", "", nil)
+	is.NoErr(err)
+	is.Equal(req.Method, "POST")
+	is.Equal(req.Body, nil)
+
+	_, err = NewRequest(ctx, "", "%zzzzz", "", nil)
+	is.True(err != nil)
+}
+
+func TestClient_Retries(t *testing.T) {
+	is := is.New(t)
+	var bodies []string
+	c := &Client{retries: 2, retryWait: time.Millisecond}
+	c.http = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		b, _ := ioutil.ReadAll(req.Body)
+		bodies = append(bodies, string(b))
+		return nil, errors.New("connection refused")
+	})}
+	req, err := NewRequest(context.Background(), "", "http://localhost:9200/_search", "{}", nil)
+	is.NoErr(err)
+	_, err = c.Do(req)
+	is.True(err != nil)
+	is.Equal(bodies, []string{"{}", "{}", "{}"}) // The body is sent again on each attempt.
+
+	// Cancelled requests are not retried.
+	bodies = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, err = NewRequest(ctx, "", "http://localhost:9200/_search", "{}", nil)
+	is.NoErr(err)
+	_, err = c.Do(req)
+	is.True(err != nil)
+	is.True(len(bodies) <= 1)
+}
+
+func TestClient_Gzip(t *testing.T) {
+	is := is.New(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Content-Encoding") != "gzip" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		gz, err := gzip.NewReader(req.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		b, _ := ioutil.ReadAll(gz)
+		w.Header().Set("Content-Encoding", "gzip")
+		gzw := gzip.NewWriter(w)
+		gzw.Write(b)
+		gzw.Close()
+	}))
+	defer server.Close()
+
+	c, err := New(Config{}, Options{Gzip: true})
+	is.NoErr(err)
+	// Explicitly asking for gzip disables the transparent decompression of net/http.
+	req, err := NewRequest(context.Background(), "POST", server.URL, `{"took":1}`, http.Header{"Accept-Encoding": []string{"gzip"}})
+	is.NoErr(err)
+	resp, err := c.Do(req)
+	is.NoErr(err)
+	defer resp.Body.Close()
+	is.Equal(resp.StatusCode, http.StatusOK)
+	is.Equal(resp.Header.Get("Content-Encoding"), "")
+	b, err := ioutil.ReadAll(resp.Body)
+	is.NoErr(err)
+	is.Equal(string(b), `{"took":1}`)
+}
+
+func TestClient_Timeout(t *testing.T) {
+	is := is.New(t)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// Headers are sent right away, the body never arrives.
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	c, err := New(Config{}, Options{Timeout: 50 * time.Millisecond})
+	is.NoErr(err)
+	resp, err := c.Get(context.Background(), server.URL)
+	is.NoErr(err)
+	_, err = ioutil.ReadAll(resp.Body)
+	is.True(err != nil) // The deadline covers reading the body.
+	resp.Body.Close()
+
+	// Request deadlines take precedence.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	req, err := NewRequest(ctx, "", server.URL, "", nil)
+	is.NoErr(err)
+	resp, err = c.Do(req)
+	is.NoErr(err)
+	deadline, _ := resp.Request.Context().Deadline()
+	is.True(time.Until(deadline) > time.Minute)
+	resp.Body.Close()
+}
+
+func TestNewTransport_NoProxy(t *testing.T) {
+	is := is.New(t)
+	// Benchmark traffic must not go through proxies set in the environment.
+	rt, err := NewTransport(Config{}, Options{})
+	is.NoErr(err)
+	is.True(rt.(*http.Transport).Proxy == nil)
+}
+
+func TestClient_CloseIdleConnections(t *testing.T) {
+	is := is.New(t)
+	closed := make(chan struct{}, 1)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	server.Config.ConnState = func(_ net.Conn, s http.ConnState) {
+		if s == http.StateClosed {
+			closed <- struct{}{}
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	// Credentials wrap the transport, which must still close its connections.
+	c, err := New(Config{BearerToken: "t0ken"}, Options{})
+	is.NoErr(err)
+	resp, err := c.Get(context.Background(), server.URL)
+	is.NoErr(err)
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.CloseIdleConnections()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("idle connection not closed")
+	}
+}
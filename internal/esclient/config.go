@@ -0,0 +1,198 @@
+// Package esclient configures connections to Elasticsearch, shared by all commands and metrics collection.
+package esclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// Environment variables used when the respective flags are not set.
+const (
+	CACertEnv      = "ESPERF_CA_CERT"
+	ClientCertEnv  = "ESPERF_CLIENT_CERT"
+	ClientKeyEnv   = "ESPERF_CLIENT_KEY"
+	InsecureEnv    = "ESPERF_INSECURE"
+	UsernameEnv    = "ESPERF_USERNAME"
+	PasswordEnv    = "ESPERF_PASSWORD"
+	APIKeyEnv      = "ESPERF_API_KEY"
+	BearerTokenEnv = "ESPERF_BEARER_TOKEN"
+)
+
+// Config holds TLS and authentication settings of connections to Elasticsearch.
+type Config struct {
+	// PEM encoded CA bundle used to verify servers. System roots are used if empty.
+	CACert string
+	// PEM encoded client certificate and key files.
+	ClientCert string
+	ClientKey  string
+	// Skip server certificate verification.
+	Insecure bool
+	// Basic authentication.
+	Username string
+	Password string
+	// Elasticsearch API key, either base64 encoded or as id:api_key.
+	APIKey      string
+	BearerToken string
+}
+
+// Default is the configuration set by flags (see AddFlags) and environment variables.
+var Default Config
+
+// AddFlags adds the connection flags to fs.
+func (c *Config) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&c.CACert, "ca_cert", "", "PEM encoded CA bundle used to verify Elasticsearch certificates. Defaults to $"+CACertEnv+" or the system roots.")
+	fs.StringVar(&c.ClientCert, "client_cert", "", "PEM encoded client certificate. Defaults to $"+ClientCertEnv+".")
+	fs.StringVar(&c.ClientKey, "client_key", "", "PEM encoded client certificate key. Defaults to $"+ClientKeyEnv+".")
+	fs.BoolVar(&c.Insecure, "insecure", false, "Skip Elasticsearch certificate verification (test clusters only). Defaults to $"+InsecureEnv+".")
+	fs.StringVar(&c.Username, "username", "", "Basic authentication user. Defaults to $"+UsernameEnv+".")
+	fs.StringVar(&c.Password, "password", "", "Basic authentication password. Defaults to $"+PasswordEnv+".")
+	fs.StringVar(&c.APIKey, "api_key", "", "Elasticsearch API key, base64 encoded or as id:api_key. Defaults to $"+APIKeyEnv+".")
+	fs.StringVar(&c.BearerToken, "bearer_token", "", "Bearer token. Defaults to $"+BearerTokenEnv+".")
+}
+
+// Redacted replaces the value of sensitive flags.
+const Redacted = "<redacted>"
+
+// sensitiveFlags are the flags holding credentials.
+var sensitiveFlags = map[string]bool{"username": true, "password": true, "api_key": true, "bearer_token": true}
+
+// FlagValue returns the value of f to be recorded (i.e. in loadspec headers), Redacted if it holds credentials.
+func FlagValue(f *pflag.Flag) string {
+	if sensitiveFlags[f.Name] {
+		return Redacted
+	}
+	return f.Value.String()
+}
+
+// resolve returns the configuration with unset fields taken from the environment.
+func (c Config) resolve() (Config, error) {
+	fromEnv := func(v *string, env string) {
+		if *v == "" {
+			*v = os.Getenv(env)
+		}
+	}
+	fromEnv(&c.CACert, CACertEnv)
+	fromEnv(&c.ClientCert, ClientCertEnv)
+	fromEnv(&c.ClientKey, ClientKeyEnv)
+	fromEnv(&c.Username, UsernameEnv)
+	fromEnv(&c.Password, PasswordEnv)
+	fromEnv(&c.APIKey, APIKeyEnv)
+	fromEnv(&c.BearerToken, BearerTokenEnv)
+	if v := os.Getenv(InsecureEnv); !c.Insecure && v != "" {
+		insecure, err := strconv.ParseBool(v)
+		if err != nil {
+			return c, fmt.Errorf("invalid $%s:%q", InsecureEnv, v)
+		}
+		c.Insecure = insecure
+	}
+
+	schemes := 0
+	for _, set := range []bool{c.Username != "" || c.Password != "", c.APIKey != "", c.BearerToken != ""} {
+		if set {
+			schemes++
+		}
+	}
+	if schemes > 1 {
+		return c, fmt.Errorf("only one of basic authentication, API key or bearer token can be set")
+	}
+	if (c.ClientCert == "") != (c.ClientKey == "") {
+		return c, fmt.Errorf("client certificate and key must be set together")
+	}
+	return c, nil
+}
+
+// TLSConfig returns the TLS configuration of connections.
+func (c Config) TLSConfig() (*tls.Config, error) {
+	c, err := c.resolve()
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{InsecureSkipVerify: c.Insecure}
+	if c.CACert != "" {
+		pem, err := ioutil.ReadFile(c.CACert)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CACert)
+		}
+	}
+	if c.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// authorization returns the Authorization header value, empty if there are no credentials.
+func (c Config) authorization() string {
+	switch {
+	case c.Username != "" || c.Password != "":
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(c.Username+":"+c.Password))
+	case c.APIKey != "":
+		key := c.APIKey
+		if strings.Contains(key, ":") {
+			key = base64.StdEncoding.EncodeToString([]byte(key))
+		}
+		return "ApiKey " + key
+	case c.BearerToken != "":
+		return "Bearer " + c.BearerToken
+	}
+	return ""
+}
+
+// Transport sets the TLS configuration of t and returns a round tripper which also authenticates requests.
+// Requests which already have an Authorization header are sent as they are.
+func (c Config) Transport(t *http.Transport) (http.RoundTripper, error) {
+	c, err := c.resolve()
+	if err != nil {
+		return nil, err
+	}
+	if t.TLSClientConfig, err = c.TLSConfig(); err != nil {
+		return nil, err
+	}
+	auth := c.authorization()
+	if auth == "" {
+		return t, nil
+	}
+	return &authTransport{auth: auth, next: t}, nil
+}
+
+type authTransport struct {
+	auth string
+	next http.RoundTripper
+}
+
+func (t *authTransport) CloseIdleConnections() {
+	if c, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != "" {
+		return t.next.RoundTrip(req)
+	}
+	// Round trippers must not modify the request.
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Authorization", t.auth)
+	return t.next.RoundTrip(r)
+}
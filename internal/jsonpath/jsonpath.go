@@ -0,0 +1,98 @@
+// Package jsonpath evaluates a subset of JSONPath over documents decoded by encoding/json: the root ($), members
+// (.name or ['name']) and array indices ([n], negative ones counting from the end).
+package jsonpath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type step struct {
+	key   string
+	index int
+	// Whether the step is an array index, otherwise it is an object member.
+	isIndex bool
+}
+
+// Path is a parsed JSONPath expression.
+type Path struct {
+	expr  string
+	steps []step
+}
+
+// Parse parses expr, i.e. $.hits.hits[0]._source['title'].
+func Parse(expr string) (*Path, error) {
+	if !strings.HasPrefix(expr, "$") {
+		return nil, fmt.Errorf("invalid JSONPath %q: must start with $", expr)
+	}
+	p := &Path{expr: expr}
+	for rest := expr[1:]; rest != ""; {
+		switch {
+		case rest[0] == '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			key := rest[1 : end+1]
+			if key == "" {
+				return nil, fmt.Errorf("invalid JSONPath %q: empty member name", expr)
+			}
+			p.steps = append(p.steps, step{key: key})
+			rest = rest[end+1:]
+		case rest[0] == '[':
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid JSONPath %q: unclosed [", expr)
+			}
+			sub := rest[1:end]
+			if len(sub) >= 2 && (sub[0] == '\'' || sub[0] == '"') && sub[len(sub)-1] == sub[0] {
+				p.steps = append(p.steps, step{key: sub[1 : len(sub)-1]})
+			} else {
+				i, err := strconv.Atoi(sub)
+				if err != nil {
+					return nil, fmt.Errorf("invalid JSONPath %q: invalid index %q", expr, sub)
+				}
+				p.steps = append(p.steps, step{index: i, isIndex: true})
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("invalid JSONPath %q: unexpected %q", expr, rest[0])
+		}
+	}
+	return p, nil
+}
+
+// Get returns the value at the path, false if there is none.
+func (p *Path) Get(doc interface{}) (interface{}, bool) {
+	v := doc
+	for _, s := range p.steps {
+		if s.isIndex {
+			a, ok := v.([]interface{})
+			if !ok {
+				return nil, false
+			}
+			i := s.index
+			if i < 0 {
+				i += len(a)
+			}
+			if i < 0 || i >= len(a) {
+				return nil, false
+			}
+			v = a[i]
+			continue
+		}
+		o, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = o[s.key]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func (p *Path) String() string {
+	return p.expr
+}
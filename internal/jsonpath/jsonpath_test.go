@@ -0,0 +1,46 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestGet(t *testing.T) {
+	is := is.New(t)
+	var doc interface{}
+	is.NoErr(json.Unmarshal([]byte(`{"hits":{"total":2,"hits":[{"_id":"1"},{"_id":"2","_source":{"first name":"Ana"}}]}}`), &doc))
+	testCases := []struct {
+		expr string
+		want interface{}
+		ok   bool
+	}{
+		{"$", doc, true},
+		{"$.hits.total", 2.0, true},
+		{"$.hits.hits[0]._id", "1", true},
+		{"$.hits.hits[-1]._id", "2", true},
+		{"$.hits.hits[1]._source['first name']", "Ana", true},
+		{`$["hits"]["total"]`, 2.0, true},
+		{"$.hits.hits[2]._id", nil, false},
+		{"$.hits.total.value", nil, false},
+		{"$.aggregations", nil, false},
+	}
+	for _, tc := range testCases {
+		p, err := Parse(tc.expr)
+		is.NoErr(err)
+		got, ok := p.Get(doc)
+		is.Equal(ok, tc.ok)
+		if tc.expr != "$" {
+			is.Equal(got, tc.want)
+		}
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	is := is.New(t)
+	for _, expr := range []string{"", "hits.total", "$.", "$.hits[", "$.hits[x]", "$hits"} {
+		_, err := Parse(expr)
+		is.True(err != nil)
+	}
+}
@@ -0,0 +1,226 @@
+package loadspec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Binary encoding layout:
+//
+//   magic | uvarint header length | JSON header | entries...
+//
+// A zero header length means the loadspec has no header. Each entry is a sequence of (uvarint tag, value)
+// pairs terminated by the endOfEntry tag. Fields holding their zero values are omitted. Strings are
+// interned: a string reference is an uvarint, zero means that a new string follows (uvarint length and
+// bytes) and must be appended to the string table, otherwise it is the position of the string in the
+// table plus one. Unknown tags are an error, new fields must get new tags.
+
+var binaryMagic = []byte("\x00esperf\x01")
+
+const (
+	endOfEntry uint64 = iota
+	delayTag
+	idTag
+	urlTag
+	sourceTag
+	methodTag
+	timeoutTag
+	assertTag
+)
+
+type binaryEncoder struct {
+	w       *bufio.Writer
+	strings map[string]uint64
+	buf     []byte
+}
+
+func newBinaryEncoder(w io.Writer, h *Header) (*binaryEncoder, error) {
+	e := &binaryEncoder{
+		w:       bufio.NewWriter(w),
+		strings: make(map[string]uint64),
+		buf:     make([]byte, binary.MaxVarintLen64),
+	}
+	e.w.Write(binaryMagic)
+	var hBuf []byte
+	if h != nil {
+		var err error
+		if hBuf, err = json.Marshal(h); err != nil {
+			return nil, err
+		}
+	}
+	e.uvarint(uint64(len(hBuf)))
+	e.w.Write(hBuf)
+	return e, nil
+}
+
+func (e *binaryEncoder) uvarint(v uint64) {
+	n := binary.PutUvarint(e.buf, v)
+	e.w.Write(e.buf[:n])
+}
+
+func (e *binaryEncoder) varint(v int64) {
+	n := binary.PutVarint(e.buf, v)
+	e.w.Write(e.buf[:n])
+}
+
+func (e *binaryEncoder) string(s string) {
+	if ref, ok := e.strings[s]; ok {
+		e.uvarint(ref)
+		return
+	}
+	e.strings[s] = uint64(len(e.strings) + 1)
+	e.uvarint(0)
+	e.uvarint(uint64(len(s)))
+	e.w.WriteString(s)
+}
+
+func (e *binaryEncoder) Encode(entry *Entry) error {
+	if entry.DelaySinceLastNanos != 0 {
+		e.uvarint(delayTag)
+		e.varint(entry.DelaySinceLastNanos)
+	}
+	if entry.ID != 0 {
+		e.uvarint(idTag)
+		e.varint(int64(entry.ID))
+	}
+	if entry.URL != "" {
+		e.uvarint(urlTag)
+		e.string(entry.URL)
+	}
+	if entry.Source != "" {
+		e.uvarint(sourceTag)
+		e.string(entry.Source)
+	}
+	if entry.Method != "" {
+		e.uvarint(methodTag)
+		e.string(entry.Method)
+	}
+	if entry.TimeoutNanos != 0 {
+		e.uvarint(timeoutTag)
+		e.varint(entry.TimeoutNanos)
+	}
+	if entry.Assert != nil {
+		// Assertions are rare and often repeated, interning their JSON encoding is compact enough.
+		buf, err := json.Marshal(entry.Assert)
+		if err != nil {
+			return err
+		}
+		e.uvarint(assertTag)
+		e.string(string(buf))
+	}
+	e.uvarint(endOfEntry)
+	return nil
+}
+
+func (e *binaryEncoder) Flush() error {
+	return e.w.Flush()
+}
+
+type binaryDecoder struct {
+	r       *bufio.Reader
+	strings []string
+	header  *Header
+	count   int
+}
+
+func newBinaryDecoder(r io.Reader) (*binaryDecoder, error) {
+	d := &binaryDecoder{r: bufio.NewReader(r)}
+	magic := make([]byte, len(binaryMagic))
+	if _, err := io.ReadFull(d.r, magic); err != nil {
+		return nil, err
+	}
+	hLen, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding loadspec header: %q", err)
+	}
+	if hLen > maxLineSize {
+		return nil, fmt.Errorf("error decoding loadspec header: length %d exceeds the limit of %d bytes", hLen, maxLineSize)
+	}
+	if hLen > 0 {
+		hBuf := make([]byte, hLen)
+		if _, err := io.ReadFull(d.r, hBuf); err != nil {
+			return nil, fmt.Errorf("error decoding loadspec header: %q", err)
+		}
+		d.header = &Header{}
+		if err := json.Unmarshal(hBuf, d.header); err != nil {
+			return nil, fmt.Errorf("error decoding loadspec header: %q", err)
+		}
+	}
+	return d, nil
+}
+
+func (d *binaryDecoder) string() (string, error) {
+	ref, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return "", err
+	}
+	if ref > 0 {
+		if ref > uint64(len(d.strings)) {
+			return "", fmt.Errorf("invalid string reference: %d", ref)
+		}
+		return d.strings[ref-1], nil
+	}
+	l, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return "", err
+	}
+	if l > maxLineSize {
+		return "", fmt.Errorf("string length %d exceeds the limit of %d bytes", l, maxLineSize)
+	}
+	buf := make([]byte, l)
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		return "", err
+	}
+	d.strings = append(d.strings, string(buf))
+	return string(buf), nil
+}
+
+func (d *binaryDecoder) Read() (*Entry, error) {
+	tag, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		// Clean end of the loadspec.
+		return nil, err
+	}
+	d.count++
+	var entry Entry
+	// A failure reading the tag also ends the loop, as zero is returned.
+	for ; tag != endOfEntry; tag, err = binary.ReadUvarint(d.r) {
+		switch tag {
+		case delayTag:
+			entry.DelaySinceLastNanos, err = binary.ReadVarint(d.r)
+		case idTag:
+			var id int64
+			id, err = binary.ReadVarint(d.r)
+			entry.ID = int(id)
+		case urlTag:
+			entry.URL, err = d.string()
+		case sourceTag:
+			entry.Source, err = d.string()
+		case methodTag:
+			entry.Method, err = d.string()
+		case timeoutTag:
+			entry.TimeoutNanos, err = binary.ReadVarint(d.r)
+		case assertTag:
+			var s string
+			if s, err = d.string(); err == nil {
+				entry.Assert = &Assertions{}
+				err = json.Unmarshal([]byte(s), entry.Assert)
+			}
+		default:
+			err = fmt.Errorf("unknown tag: %d", tag)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("error decoding loadspec entry #%d: %q", d.count, err)
+	}
+	return &entry, nil
+}
@@ -1,11 +1,69 @@
 package loadspec
 
+import (
+	"sort"
+
+	"github.com/danielfireman/esperf/internal/jsonpath"
+)
+
 type Entry struct {
 	// By using delay since last instead of timestamp we make replay a lot easier.
 	DelaySinceLastNanos int64  `json:"delay_since_last_nanos"`
 	URL                 string `json:"url"`
 	Source              string `json:"source"`
-    ID int `json:"id"`
+	ID                  int    `json:"id"`
+	// HTTP method. Empty means GET.
+	Method string `json:"method,omitempty"`
+	// Deadline of the whole request, from sending to reading the response. Zero means the replay --timeout.
+	TimeoutNanos int64 `json:"timeout_nanos,omitempty"`
+	// Checked against every response, nil means nothing is checked.
+	Assert *Assertions `json:"assert,omitempty"`
+}
+
+// Assertions are expectations about the response of an entry. Zero values are not checked.
+type Assertions struct {
+	// HTTP status code.
+	Status int `json:"status,omitempty"`
+	// Exact and minimum hits.total.
+	HitsTotal    *int64 `json:"hits_total,omitempty"`
+	MinHitsTotal int64  `json:"min_hits_total,omitempty"`
+	// Values expected at JSONPath expressions, i.e. {"$.hits.hits[0]._id":"42"}.
+	JSONPath map[string]interface{} `json:"json_path,omitempty"`
+	// Maximum took, in milliseconds.
+	MaxTookMillis int64 `json:"max_took_millis,omitempty"`
+
+	// JSONPath assertions parsed by Compile.
+	paths []PathAssertion
+}
+
+// PathAssertion is a parsed JSONPath assertion.
+type PathAssertion struct {
+	Expr string
+	Path *jsonpath.Path
+	// Value expected at Path.
+	Want interface{}
+}
+
+// Compile parses the JSONPath expressions, so they are not parsed again for every response. Readers compile
+// the assertions of every entry they read.
+func (a *Assertions) Compile() error {
+	paths := make([]PathAssertion, 0, len(a.JSONPath))
+	for expr, want := range a.JSONPath {
+		p, err := jsonpath.Parse(expr)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, PathAssertion{Expr: expr, Path: p, Want: want})
+	}
+	// Map iteration order is random, keeping the order predictable.
+	sort.Slice(paths, func(i, j int) bool { return paths[i].Expr < paths[j].Expr })
+	a.paths = paths
+	return nil
+}
+
+// Paths returns the JSONPath assertions parsed by Compile, sorted by expression.
+func (a *Assertions) Paths() []PathAssertion {
+	return a.paths
 }
 
 // ByTimestampNanos implements sort.Interface for []Entry based on
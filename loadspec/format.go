@@ -0,0 +1,132 @@
+package loadspec
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// Encoding is the way entries are serialized.
+type Encoding string
+
+const (
+	// JSONEncoding is the newline delimited JSON encoding, one entry per line.
+	JSONEncoding Encoding = "json"
+	// BinaryEncoding is a compact encoding which interns repeated URLs and sources.
+	BinaryEncoding Encoding = "bin"
+)
+
+// Compression is the compression algorithm applied on top of the encoding.
+type Compression string
+
+const (
+	NoCompression   Compression = ""
+	GzipCompression Compression = "gz"
+	ZstdCompression Compression = "zst"
+)
+
+// Format describes how a loadspec is stored.
+type Format struct {
+	Encoding    Encoding
+	Compression Compression
+}
+
+// DefaultFormat is the uncompressed newline delimited JSON format.
+var DefaultFormat = Format{Encoding: JSONEncoding}
+
+// String returns the format name, for instance "json" or "bin.gz".
+func (f Format) String() string {
+	if f.Compression == NoCompression {
+		return string(f.Encoding)
+	}
+	return string(f.Encoding) + "." + string(f.Compression)
+}
+
+// ParseFormat parses format names as returned by Format.String.
+func ParseFormat(s string) (Format, error) {
+	p := strings.SplitN(s, ".", 2)
+	f := Format{Encoding: Encoding(p[0])}
+	if len(p) > 1 {
+		f.Compression = Compression(p[1])
+	}
+	switch f.Encoding {
+	case JSONEncoding, BinaryEncoding:
+	default:
+		return Format{}, fmt.Errorf("invalid loadspec encoding:%q", s)
+	}
+	switch f.Compression {
+	case NoCompression, GzipCompression, ZstdCompression:
+	default:
+		return Format{}, fmt.Errorf("invalid loadspec compression:%q", s)
+	}
+	return f, nil
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Decompress detects the format of the loadspec and returns a reader of its decompressed content. Callers
+// must close the returned reader, which does not close r.
+func Decompress(r io.Reader) (io.ReadCloser, Format, error) {
+	br := bufio.NewReader(r)
+	f := Format{Encoding: JSONEncoding}
+	var rc io.ReadCloser = ioutil.NopCloser(br)
+	magic, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, f, err
+		}
+		f.Compression = GzipCompression
+		rc = gz
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := newZstdReader(br)
+		if err != nil {
+			return nil, f, err
+		}
+		f.Compression = ZstdCompression
+		rc = zr
+	}
+	if f.Compression != NoCompression {
+		br = bufio.NewReader(rc)
+		rc = &readCloser{br, rc}
+	}
+	magic, _ = br.Peek(len(binaryMagic))
+	if bytes.Equal(magic, binaryMagic) {
+		f.Encoding = BinaryEncoding
+	}
+	return rc, f, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// compress wraps w according to the passed-in compression. Callers must close the returned writer, which
+// does not close w.
+func compress(w io.Writer, c Compression) (io.WriteCloser, error) {
+	switch c {
+	case GzipCompression:
+		return gzip.NewWriter(w), nil
+	case ZstdCompression:
+		return newZstdWriter(w)
+	default:
+		return nopWriteCloser{w}, nil
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
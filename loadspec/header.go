@@ -0,0 +1,45 @@
+package loadspec
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// FormatVersion is the version of the loadspec format written by this package.
+const FormatVersion = 1
+
+// Header keeps metadata about how a loadspec was made. It is optional and, when present, it is the
+// first line of the loadspec. That makes experiments traceable to their inputs.
+type Header struct {
+	Version int `json:"loadspec_version"`
+	// Command that generated the loadspec, for instance "esperf loadspec gen".
+	Command string `json:"command"`
+	// Flags explicitly set when calling the command.
+	Flags map[string]string `json:"flags,omitempty"`
+	// Positional arguments passed in to the command.
+	Args []string `json:"args,omitempty"`
+	// Seed used to initialize the random number generator.
+	Seed int64 `json:"seed,omitempty"`
+	// Names of the files (i.e. slowlogs or other loadspecs) the loadspec was made of.
+	Sources []string `json:"sources,omitempty"`
+	// SHA-256 of the anonymization map used to generate the loadspec.
+	AnonMapSHA256 string    `json:"anon_map_sha256,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	// Headers of the loadspecs this loadspec was derived from.
+	Parents []*Header `json:"parents,omitempty"`
+}
+
+// DecodeHeader returns the header encoded in the passed-in line, or nil if the line is not a header.
+func DecodeHeader(line []byte) (*Header, error) {
+	var v struct {
+		Version *int `json:"loadspec_version"`
+	}
+	if err := json.Unmarshal(line, &v); err != nil || v.Version == nil {
+		return nil, nil
+	}
+	var h Header
+	if err := json.Unmarshal(line, &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
@@ -3,6 +3,7 @@ package loadspec
 import (
 	"fmt"
 	"math"
+	"math/rand"
 	"strconv"
 	"strings"
 )
@@ -13,13 +14,16 @@ const (
 	poissonLoadDef = "poisson"
 )
 
-type interArrival interface {
+// InterArrival generates a stream of inter-arrival times, in nanoseconds.
+type InterArrival interface {
 	Next() int64
 }
 
-func newInterArrival(def string) (interArrival, error) {
+// NewInterArrival parses the inter-arrival definition, i.e. const:10 or poisson:10. Random inter-arrival
+// times are drawn from rnd.
+func NewInterArrival(def string, rnd *rand.Rand) (InterArrival, error) {
 	p := strings.Split(def, loadDefSep)
-	if len(p) < 1 {
+	if len(p) != 2 {
 		return nil, fmt.Errorf("invalid inter arrival definition:%s", def)
 	}
 	switch p[0] {
@@ -34,7 +38,7 @@ func newInterArrival(def string) (interArrival, error) {
 		if err != nil {
 			return nil, fmt.Errorf("invalid inter arrival definition:%q", err)
 		}
-		return &Poisson{lambda}, nil
+		return &Poisson{lambda, rnd}, nil
 	default:
 		return nil, fmt.Errorf("invalid load type:%s", p[0])
 	}
@@ -54,10 +58,11 @@ type Poisson struct {
 	// The rate parameter λ is a measure of frequency: the average rate of events (in this case, messages sent)
 	// per unit of time (in this case, seconds).
 	lambda float64
+	rnd    *rand.Rand
 }
 
 func (p *Poisson) Next() int64 {
 	// NOTE: Implementation follows:
 	// http://preshing.com/20111007/how-to-generate-random-timings-for-a-poisson-process/
-	return int64(-math.Log(1.0-randGen.Float64()) / float64(p.lambda) * float64(1e9))
+	return int64(-math.Log(1.0-p.rnd.Float64()) / float64(p.lambda) * float64(1e9))
 }
@@ -0,0 +1,161 @@
+package loadspec
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Query bodies can be pretty big, the default bufio.Scanner token size is not enough.
+const maxLineSize = 64 * 1024 * 1024
+
+type decoder interface {
+	Read() (*Entry, error)
+}
+
+// Reader reads loadspec entries. The loadspec format is detected automatically.
+type Reader struct {
+	dec    decoder
+	rc     io.ReadCloser
+	header *Header
+	format Format
+}
+
+// NewReader returns a new Reader that reads from r. The loadspec header, if present, is read right away.
+// Callers must call Close when done, which does not close r.
+func NewReader(r io.Reader) (*Reader, error) {
+	rc, f, err := Decompress(r)
+	if err != nil {
+		return nil, err
+	}
+	reader := &Reader{rc: rc, format: f}
+	switch f.Encoding {
+	case BinaryEncoding:
+		dec, err := newBinaryDecoder(rc)
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		reader.dec = dec
+		reader.header = dec.header
+	default:
+		dec, err := newJSONDecoder(rc)
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		reader.dec = dec
+		reader.header = dec.header
+	}
+	return reader, nil
+}
+
+// Header returns the loadspec header or nil if the loadspec does not have one.
+func (r *Reader) Header() *Header {
+	return r.header
+}
+
+// Format returns the detected loadspec format.
+func (r *Reader) Format() Format {
+	return r.format
+}
+
+// Read reads the next entry from the loadspec, compiling its assertions. It returns io.EOF when there are no
+// more entries.
+func (r *Reader) Read() (*Entry, error) {
+	e, err := r.dec.Read()
+	if err != nil {
+		return nil, err
+	}
+	if e.Assert != nil {
+		if err := e.Assert.Compile(); err != nil {
+			return nil, fmt.Errorf("entry %d: invalid assertion: %q", e.ID, err)
+		}
+	}
+	return e, nil
+}
+
+// Close releases the resources used to decompress the loadspec.
+func (r *Reader) Close() error {
+	return r.rc.Close()
+}
+
+// ReadAll reads the header (nil if not present) and all entries from r.
+func ReadAll(r io.Reader) (*Header, []Entry, error) {
+	reader, err := NewReader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	var entries []Entry
+	for {
+		entry, err := reader.Read()
+		if err == io.EOF {
+			return reader.Header(), entries, reader.Close()
+		}
+		if err != nil {
+			reader.Close()
+			return nil, nil, err
+		}
+		entries = append(entries, *entry)
+	}
+}
+
+type jsonDecoder struct {
+	scanner *bufio.Scanner
+	line    int
+	header  *Header
+	// First line, kept here if it is not a header.
+	pending []byte
+}
+
+func newJSONDecoder(r io.Reader) (*jsonDecoder, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	d := &jsonDecoder{scanner: scanner}
+	line, err := d.nextLine()
+	if err == io.EOF {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.header, err = DecodeHeader(line)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding loadspec header: %q", err)
+	}
+	if d.header == nil {
+		d.pending = line
+	}
+	return d, nil
+}
+
+func (d *jsonDecoder) nextLine() ([]byte, error) {
+	if d.pending != nil {
+		line := d.pending
+		d.pending = nil
+		return line, nil
+	}
+	for d.scanner.Scan() {
+		d.line++
+		if len(d.scanner.Bytes()) > 0 {
+			return append([]byte(nil), d.scanner.Bytes()...), nil
+		}
+	}
+	if err := d.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (d *jsonDecoder) Read() (*Entry, error) {
+	line, err := d.nextLine()
+	if err != nil {
+		return nil, err
+	}
+	var entry Entry
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return nil, fmt.Errorf("error decoding loadspec entry at line %d: %q", d.line, err)
+	}
+	return &entry, nil
+}
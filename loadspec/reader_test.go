@@ -0,0 +1,137 @@
+package loadspec
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestReadWrite(t *testing.T) {
+	hits := int64(3)
+	entries := []Entry{
+		{ID: 0, URL: "http://localhost:9200/index/_search", Source: "{}"},
+		{ID: 1, DelaySinceLastNanos: 10, URL: "http://localhost:9200/index/_search", Source: "{}", Assert: &Assertions{Status: 200, HitsTotal: &hits, JSONPath: map[string]interface{}{"$.hits.hits[0]._id": "42"}, MaxTookMillis: 100}},
+		{ID: 2, DelaySinceLastNanos: 1e9, URL: "http://localhost:9200/other/_search", Source: `{"size":1}`, Method: "POST", TimeoutNanos: 5e8},
+	}
+	// Read entries have their assertions compiled.
+	if err := entries[1].Assert.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	formats := []string{"json", "json.gz", "json.zst", "bin", "bin.gz", "bin.zst"}
+	for _, name := range formats {
+		t.Run(name, func(t *testing.T) {
+			is := is.New(t)
+			f, err := ParseFormat(name)
+			is.NoErr(err)
+			var buf bytes.Buffer
+			is.NoErr(WriteAll(&buf, f, &Header{Command: "esperf loadspec gen", Seed: 42, Sources: []string{"dict.txt"}}, entries))
+
+			r, err := NewReader(&buf)
+			is.NoErr(err)
+			is.Equal(r.Format(), f)
+			is.NoErr(r.Close())
+
+			buf.Reset()
+			is.NoErr(WriteAll(&buf, f, &Header{Command: "esperf loadspec gen", Seed: 42, Sources: []string{"dict.txt"}}, entries))
+			h, got, err := ReadAll(&buf)
+			is.NoErr(err)
+			is.Equal(h.Version, FormatVersion)
+			is.Equal(h.Command, "esperf loadspec gen")
+			is.Equal(h.Seed, int64(42))
+			is.Equal(h.Sources, []string{"dict.txt"})
+			is.Equal(got, entries)
+		})
+	}
+}
+
+func TestBinaryEncoding_InternsStrings(t *testing.T) {
+	is := is.New(t)
+	e := Entry{URL: "http://localhost:9200/index/_search", Source: `{"query":{"match_all":{}}}`}
+	var one, two bytes.Buffer
+	is.NoErr(WriteAll(&one, Format{Encoding: BinaryEncoding}, nil, []Entry{e}))
+	is.NoErr(WriteAll(&two, Format{Encoding: BinaryEncoding}, nil, []Entry{e, e}))
+	// Second entry only references the string table.
+	is.Equal(two.Len()-one.Len(), 5)
+
+	h, got, err := ReadAll(&two)
+	is.NoErr(err)
+	is.True(h == nil)
+	is.Equal(got, []Entry{e, e})
+}
+
+func TestParseFormat(t *testing.T) {
+	is := is.New(t)
+	f, err := ParseFormat("bin.gz")
+	is.NoErr(err)
+	is.Equal(f, Format{Encoding: BinaryEncoding, Compression: GzipCompression})
+	is.Equal(f.String(), "bin.gz")
+	_, err = ParseFormat("xml")
+	is.True(err != nil)
+	_, err = ParseFormat("json.bz2")
+	is.True(err != nil)
+}
+
+func TestReadAll_WithoutHeader(t *testing.T) {
+	is := is.New(t)
+	spec := `{"delay_since_last_nanos":0,"url":"http://localhost:9200/index/_search","source":"{}","id":0}
+
+{"delay_since_last_nanos":10,"url":"http://localhost:9200/index/_search","source":"{}","id":1}`
+	h, entries, err := ReadAll(strings.NewReader(spec))
+	is.NoErr(err)
+	is.True(h == nil)
+	is.Equal(len(entries), 2)
+	is.Equal(entries[1].DelaySinceLastNanos, int64(10))
+}
+
+func TestReadAll_Empty(t *testing.T) {
+	is := is.New(t)
+	h, entries, err := ReadAll(strings.NewReader(""))
+	is.NoErr(err)
+	is.True(h == nil)
+	is.Equal(len(entries), 0)
+}
+
+func TestNewReader_EarlyClose(t *testing.T) {
+	is := is.New(t)
+	entries := make([]Entry, 10000)
+	for i := range entries {
+		entries[i] = Entry{ID: i, URL: "http://localhost:9200/index/_search", Source: fmt.Sprintf(`{"query":{"term":{"id":%d}}}`, i)}
+	}
+	for _, name := range []string{"json.gz", "json.zst"} {
+		f, err := ParseFormat(name)
+		is.NoErr(err)
+		var buf bytes.Buffer
+		is.NoErr(WriteAll(&buf, f, nil, entries))
+		r, err := NewReader(&buf)
+		is.NoErr(err)
+		_, err = r.Read()
+		is.NoErr(err)
+		is.NoErr(r.Close()) // Most of the loadspec was not read.
+	}
+}
+
+func TestReadAll_InvalidAssertion(t *testing.T) {
+	is := is.New(t)
+	spec := `{"delay_since_last_nanos":0,"url":"http://localhost:9200/index/_search","source":"{}","id":7,"assert":{"json_path":{"hits.total":1}}}`
+	_, _, err := ReadAll(strings.NewReader(spec))
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "entry 7"))
+}
+
+func TestReadAll_CorruptBinary(t *testing.T) {
+	is := is.New(t)
+	huge := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}
+	for _, spec := range [][]byte{
+		// Header length.
+		append(append([]byte{}, binaryMagic...), huge...),
+		// Length of a new URL string.
+		append(append([]byte{}, binaryMagic...), append([]byte{0, byte(urlTag), 0}, huge...)...),
+	} {
+		_, _, err := ReadAll(bytes.NewReader(spec))
+		is.True(err != nil)
+		is.True(strings.Contains(err.Error(), "exceeds the limit"))
+	}
+}
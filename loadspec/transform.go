@@ -0,0 +1,131 @@
+package loadspec
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Functions in this file derive new loadspecs from existing ones. As entries only carry the delay since the
+// previous entry, all of them work on absolute arrival times (relative to the beginning of the test) and
+// re-encode delays afterwards. Entries in the returned loadspecs are always renumbered.
+
+// arrivals returns the arrival time of each entry, relative to the beginning of the test.
+func arrivals(entries []Entry) []int64 {
+	ret := make([]int64, len(entries))
+	var elapsed int64
+	for i, e := range entries {
+		elapsed += e.DelaySinceLastNanos
+		ret[i] = elapsed
+	}
+	return ret
+}
+
+// timedEntry is an entry paired with its absolute arrival time.
+type timedEntry struct {
+	arrival int64
+	entry   Entry
+}
+
+func timed(entries []Entry) []timedEntry {
+	ret := make([]timedEntry, len(entries))
+	for i, a := range arrivals(entries) {
+		ret[i] = timedEntry{a, entries[i]}
+	}
+	return ret
+}
+
+// fromTimed re-encodes arrival times as delays since last entry and renumbers entries.
+func fromTimed(te []timedEntry) []Entry {
+	ret := make([]Entry, len(te))
+	var previous int64
+	for i, t := range te {
+		ret[i] = t.entry
+		ret[i].DelaySinceLastNanos = t.arrival - previous
+		ret[i].ID = i
+		previous = t.arrival
+	}
+	return ret
+}
+
+// Renumber sets entries ID according to their position in the loadspec.
+func Renumber(entries []Entry) {
+	for i := range entries {
+		entries[i].ID = i
+	}
+}
+
+// Slice returns the entries arriving within the [from, to) time window. The returned loadspec starts at from.
+// A non-positive to means until the end of the loadspec.
+func Slice(entries []Entry, from, to time.Duration) []Entry {
+	var ret []timedEntry
+	for _, t := range timed(entries) {
+		if t.arrival < from.Nanoseconds() || (to > 0 && t.arrival >= to.Nanoseconds()) {
+			continue
+		}
+		t.arrival -= from.Nanoseconds()
+		ret = append(ret, t)
+	}
+	return fromTimed(ret)
+}
+
+// Shift moves all entries in time. Positive values delay the whole loadspec. When negative, entries which
+// would arrive before the beginning of the test are discarded.
+func Shift(entries []Entry, by time.Duration) []Entry {
+	var ret []timedEntry
+	for _, t := range timed(entries) {
+		t.arrival += by.Nanoseconds()
+		if t.arrival < 0 {
+			continue
+		}
+		ret = append(ret, t)
+	}
+	return fromTimed(ret)
+}
+
+// Filter returns the entries for which keep returns true. Arrival times of the kept entries are preserved.
+func Filter(entries []Entry, keep func(e *Entry) bool) []Entry {
+	var ret []timedEntry
+	for _, t := range timed(entries) {
+		if keep(&t.entry) {
+			ret = append(ret, t)
+		}
+	}
+	return fromTimed(ret)
+}
+
+// Sample returns a random sample of the entries, each of them being kept with the passed-in probability.
+// Arrival times of the kept entries are preserved.
+func Sample(entries []Entry, ratio float64, r *rand.Rand) []Entry {
+	return Filter(entries, func(*Entry) bool {
+		return r.Float64() < ratio
+	})
+}
+
+// Concat returns a loadspec that replays the passed-in loadspecs one after another, waiting gap between
+// the last entry of a loadspec and the first entry of the next one.
+func Concat(gap time.Duration, specs ...[]Entry) []Entry {
+	var ret []Entry
+	for i, s := range specs {
+		s = append([]Entry(nil), s...)
+		if i > 0 && len(s) > 0 {
+			s[0].DelaySinceLastNanos += gap.Nanoseconds()
+		}
+		ret = append(ret, s...)
+	}
+	Renumber(ret)
+	return ret
+}
+
+// Interleave superimposes the passed-in loadspecs, returning a single loadspec which merges their arrival
+// streams. Arrival ties are broken using the order of the loadspecs.
+func Interleave(specs ...[]Entry) []Entry {
+	var merged []timedEntry
+	for _, s := range specs {
+		merged = append(merged, timed(s)...)
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].arrival < merged[j].arrival
+	})
+	return fromTimed(merged)
+}
@@ -0,0 +1,88 @@
+package loadspec
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// newSpec creates a loadspec which entries arrive at the passed-in times (in seconds).
+func newSpec(url string, arrivalSecs ...int64) []Entry {
+	var ret []Entry
+	var previous int64
+	for i, a := range arrivalSecs {
+		ret = append(ret, Entry{ID: i, URL: url, DelaySinceLastNanos: (a - previous) * int64(time.Second)})
+		previous = a
+	}
+	return ret
+}
+
+func arrivalSecs(entries []Entry) []int64 {
+	var ret []int64
+	for _, a := range arrivals(entries) {
+		ret = append(ret, a/int64(time.Second))
+	}
+	return ret
+}
+
+func ids(entries []Entry) []int {
+	var ret []int
+	for _, e := range entries {
+		ret = append(ret, e.ID)
+	}
+	return ret
+}
+
+func TestSlice(t *testing.T) {
+	is := is.New(t)
+	s := Slice(newSpec("a", 0, 1, 2, 3, 4), 1*time.Second, 3*time.Second)
+	is.Equal(arrivalSecs(s), []int64{0, 1})
+	is.Equal(ids(s), []int{0, 1})
+
+	s = Slice(newSpec("a", 0, 1, 2, 3, 4), 3*time.Second, 0)
+	is.Equal(arrivalSecs(s), []int64{0, 1})
+}
+
+func TestShift(t *testing.T) {
+	is := is.New(t)
+	is.Equal(arrivalSecs(Shift(newSpec("a", 0, 1, 2), 2*time.Second)), []int64{2, 3, 4})
+	is.Equal(arrivalSecs(Shift(newSpec("a", 0, 1, 2), -1*time.Second)), []int64{0, 1})
+}
+
+func TestFilter(t *testing.T) {
+	is := is.New(t)
+	s := newSpec("a", 0, 1, 2, 3)
+	s[1].URL = "b"
+	s[3].URL = "b"
+	f := Filter(s, func(e *Entry) bool { return e.URL == "b" })
+	is.Equal(arrivalSecs(f), []int64{1, 3})
+	is.Equal(ids(f), []int{0, 1})
+}
+
+func TestSample(t *testing.T) {
+	is := is.New(t)
+	s := newSpec("a", 0, 1, 2, 3)
+	is.Equal(len(Sample(s, 0, rand.New(rand.NewSource(1)))), 0)
+	is.Equal(arrivalSecs(Sample(s, 1, rand.New(rand.NewSource(1)))), []int64{0, 1, 2, 3})
+}
+
+func TestConcat(t *testing.T) {
+	is := is.New(t)
+	s := Concat(time.Second, newSpec("a", 0, 1), newSpec("b", 0, 2))
+	is.Equal(arrivalSecs(s), []int64{0, 1, 2, 4})
+	is.Equal(ids(s), []int{0, 1, 2, 3})
+}
+
+func TestInterleave(t *testing.T) {
+	is := is.New(t)
+	s := Interleave(newSpec("a", 0, 2, 4), newSpec("b", 1, 2, 3))
+	is.Equal(arrivalSecs(s), []int64{0, 1, 2, 2, 3, 4})
+	var urls []string
+	for _, e := range s {
+		urls = append(urls, e.URL)
+	}
+	is.Equal(urls, []string{"a", "b", "a", "b", "b", "a"})
+	is.Equal(ids(s), []int{0, 1, 2, 3, 4, 5})
+}
@@ -0,0 +1,92 @@
+package loadspec
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+type encoder interface {
+	Encode(e *Entry) error
+	Flush() error
+}
+
+// Writer writes loadspec entries using the format it was created with.
+type Writer struct {
+	enc encoder
+	wc  io.WriteCloser
+}
+
+// NewWriter returns a new Writer that writes to w using the passed-in format. If h is not nil, it is
+// written as the loadspec header. Callers must call Close when done, which does not close w.
+func NewWriter(w io.Writer, f Format, h *Header) (*Writer, error) {
+	wc, err := compress(w, f.Compression)
+	if err != nil {
+		return nil, err
+	}
+	if h != nil && h.Version == 0 {
+		h.Version = FormatVersion
+	}
+	writer := &Writer{wc: wc}
+	switch f.Encoding {
+	case BinaryEncoding:
+		writer.enc, err = newBinaryEncoder(wc, h)
+	default:
+		writer.enc, err = newJSONEncoder(wc, h)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// Write writes a single entry.
+func (w *Writer) Write(e *Entry) error {
+	return w.enc.Encode(e)
+}
+
+// Close flushes any buffered data and finishes the compressed stream, if any.
+func (w *Writer) Close() error {
+	if err := w.enc.Flush(); err != nil {
+		return err
+	}
+	return w.wc.Close()
+}
+
+// WriteAll writes the header (if not nil) and all entries to w using the passed-in format.
+func WriteAll(w io.Writer, f Format, h *Header, entries []Entry) error {
+	writer, err := NewWriter(w, f, h)
+	if err != nil {
+		return err
+	}
+	for i := range entries {
+		if err := writer.Write(&entries[i]); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+type jsonEncoder struct {
+	*json.Encoder
+	w *bufio.Writer
+}
+
+func newJSONEncoder(w io.Writer, h *Header) (*jsonEncoder, error) {
+	bw := bufio.NewWriter(w)
+	e := &jsonEncoder{json.NewEncoder(bw), bw}
+	if h != nil {
+		if err := e.Encoder.Encode(h); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func (e *jsonEncoder) Encode(entry *Entry) error {
+	return e.Encoder.Encode(entry)
+}
+
+func (e *jsonEncoder) Flush() error {
+	return e.w.Flush()
+}
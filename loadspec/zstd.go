@@ -0,0 +1,24 @@
+package loadspec
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// The Go standard library does not support zstd, the vendored pure Go implementation is used instead.
+
+// newZstdReader returns a reader of the decompressed content of r. Closing it releases the decoder, which
+// might be done before reading everything.
+func newZstdReader(r io.Reader) (io.ReadCloser, error) {
+	d, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
+
+// newZstdWriter returns a writer compressing to w. Closing it flushes the compressed content, w is not closed.
+func newZstdWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w)
+}
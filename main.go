@@ -10,6 +10,12 @@ import (
 func main() {
 	if err := cmd.RootCmd.Execute(); err != nil {
 		fmt.Println(err)
+		// Errors might carry specific exit codes, i.e. load tests which did not meet their thresholds.
+		if e, ok := err.(interface {
+			ExitCode() int
+		}); ok {
+			os.Exit(e.ExitCode())
+		}
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,42 @@
+package reporter
+
+import (
+	"fmt"
+)
+
+// MismatchReport keeps the assertions responses did not pass.
+type MismatchReport struct {
+	*csvRows
+}
+
+// Mismatch is an assertion a response did not pass.
+type Mismatch struct {
+	// Unix timestamp, in seconds.
+	TS int64
+	// ID of the loadspec entry.
+	ID int
+	// HTTP status code.
+	Code      int
+	Assertion string
+	Expected  string
+	Actual    string
+}
+
+func NewMismatchReport(path string) (*MismatchReport, error) {
+	rows, err := newCSVRows(path, []string{"ts", "id", "code", "assertion", "expected", "actual"})
+	if err != nil {
+		return nil, err
+	}
+	return &MismatchReport{rows}, nil
+}
+
+func (m *MismatchReport) Mismatched(mm Mismatch) {
+	m.c <- []string{
+		fmt.Sprintf("%d", mm.TS),
+		fmt.Sprintf("%d", mm.ID),
+		fmt.Sprintf("%d", mm.Code),
+		mm.Assertion,
+		mm.Expected,
+		mm.Actual,
+	}
+}
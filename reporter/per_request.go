@@ -1,53 +1,92 @@
 package reporter
 
 import (
-	"bufio"
-	"encoding/csv"
 	"fmt"
-	"os"
 )
 
 // PerRequestReport that tracks and keeps metrics for each request across the whole
 // load test.
 type PerRequestReport struct {
-	f *os.File
-	w *csv.Writer
-	c chan []string
+	*csvRows
+}
+
+// Request statuses.
+const (
+	// A response was received and the request succeeded.
+	StatusOK = "ok"
+	// The request failed, either there was no response or it was an error.
+	StatusError = "error"
+	// A response was received, but results are partial: some shards failed, the search timed out or
+	// terminated early.
+	StatusPartial = "partial"
+	// The request did not complete before its deadline.
+	StatusTimeout = "timeout"
+	// The server asked to back off (429 or 503).
+	StatusThrottled = "throttled"
+	// The request was not sent because the load test was paused.
+	StatusDropped = "dropped"
+)
+
+// Request is the outcome of one request.
+type Request struct {
+	// Unix timestamp, in seconds.
+	TS int64
+	// HTTP status code, zero if there was no response.
+	Code         int
+	TookInMillis int64
+	// Latency seen by the client, in microseconds.
+	LatencyMicros int64
+	// ID of the loadspec entry.
+	ID     int
+	Status string
+	// Why the request did not succeed: the Elasticsearch error type (or the error class if there is none) and
+	// reason. Empty if it succeeded.
+	ErrorType   string
+	ErrorReason string
+	// Shards searched and failed, and whether the search timed out or terminated early. Zero if the response
+	// was not a successful search.
+	ShardsTotal     int
+	ShardsFailed    int
+	TimedOut        bool
+	TerminatedEarly bool
+	// Hits of successful searches, either exact (eq) or a lower bound (gte). The relation is empty if the total
+	// is unknown.
+	HitsTotal    int64
+	HitsRelation string
+	// Request and response body sizes, in bytes.
+	RequestBytes  int
+	ResponseBytes int
 }
 
 func NewPerRequestReport(path string) (*PerRequestReport, error) {
-	f, err := os.Create(path)
+	rows, err := newCSVRows(path, []string{"ts", "code", "took_in_millis", "latency_micros", "id", "status", "error_type", "error_reason", "shards_total", "shards_failed", "timed_out", "terminated_early", "hits_total", "hits_relation", "request_bytes", "response_bytes"})
 	if err != nil {
 		return nil, err
 	}
-	w := csv.NewWriter(bufio.NewWriter(f))
-	w.Write([]string{"ts", "code", "took_in_millis", "id"})
-	if err := w.Error(); err != nil {
-		return nil, w.Error()
-	}
-	return &PerRequestReport{f, w, make(chan []string, 10000)}, nil
+	return &PerRequestReport{rows}, nil
 }
 
-func (p *PerRequestReport) RequestProcessed(ts int64, code int, tookInMillis, latency int64, id int) {
+func (p *PerRequestReport) RequestProcessed(r Request) {
+	hitsTotal := ""
+	if r.HitsRelation != "" {
+		hitsTotal = fmt.Sprintf("%d", r.HitsTotal)
+	}
 	p.c <- []string{
-		fmt.Sprintf("%d", ts),
-		fmt.Sprintf("%d", code),
-		fmt.Sprintf("%d", tookInMillis),
-		fmt.Sprintf("%d", latency),
-		fmt.Sprintf("%d", id),
+		fmt.Sprintf("%d", r.TS),
+		fmt.Sprintf("%d", r.Code),
+		fmt.Sprintf("%d", r.TookInMillis),
+		fmt.Sprintf("%d", r.LatencyMicros),
+		fmt.Sprintf("%d", r.ID),
+		r.Status,
+		r.ErrorType,
+		r.ErrorReason,
+		fmt.Sprintf("%d", r.ShardsTotal),
+		fmt.Sprintf("%d", r.ShardsFailed),
+		fmt.Sprintf("%t", r.TimedOut),
+		fmt.Sprintf("%t", r.TerminatedEarly),
+		hitsTotal,
+		r.HitsRelation,
+		fmt.Sprintf("%d", r.RequestBytes),
+		fmt.Sprintf("%d", r.ResponseBytes),
 	}
 }
-
-func (p *PerRequestReport) Start() {
-	go func() {
-		for t := range p.c {
-			p.w.Write(t)
-		}
-	}()
-}
-
-func (p *PerRequestReport) Finish() {
-	close(p.c)
-	p.w.Flush()
-	p.f.Close()
-}
@@ -60,11 +60,12 @@ func (r *Reporter) dumpToStores() {
 	now := time.Now().Unix()
 	for _, c := range r.collectors {
 		ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
-		if err := c.Collect(ctx); err != nil {
+		err := c.Collect(ctx)
+		cancel()
+		if err != nil {
+			// Metrics from the load test itself must be written anyway.
 			log.Printf("error collecting %s: %q", c.Name(), err)
-			return
 		}
-		cancel()
 	}
 	for _, s := range r.stores {
 		if err := s.Write(now); err != nil {
@@ -91,8 +92,14 @@ func (r *Reporter) Start() {
 	}()
 }
 
+// Finish dumps metrics one last time and closes all stores.
 func (r *Reporter) Finish() {
 	r.endChan <- struct{}{}
 	r.waiter.Wait()
 	close(r.endChan)
+	for _, s := range r.stores {
+		if err := s.Close(); err != nil {
+			log.Printf("error closing store: %q", err)
+		}
+	}
 }
@@ -0,0 +1,44 @@
+package reporter
+
+import (
+	"encoding/csv"
+	"os"
+)
+
+// csvRows writes rows to a CSV file without blocking the load test.
+type csvRows struct {
+	f    *os.File
+	w    *csv.Writer
+	c    chan []string
+	done chan struct{}
+}
+
+func newCSVRows(path string, header []string) (*csvRows, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := csv.NewWriter(f)
+	w.Write(header)
+	if err := w.Error(); err != nil {
+		return nil, w.Error()
+	}
+	return &csvRows{f, w, make(chan []string, 10000), make(chan struct{})}, nil
+}
+
+func (r *csvRows) Start() {
+	go func() {
+		defer close(r.done)
+		for t := range r.c {
+			r.w.Write(t)
+		}
+	}()
+}
+
+// Finish writes all rows and closes the file.
+func (r *csvRows) Finish() {
+	close(r.c)
+	<-r.done
+	r.w.Flush()
+	r.f.Close()
+}
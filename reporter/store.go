@@ -39,7 +39,6 @@ func CSVStore(i interface{}, path string) (Store, error) {
 		w.Write(append([]string{"ts"}, igs.Header...))
 		return &CSVIntGaugeSet{fileAndWriter{f, w}, igs}, nil
 	}
-	return nil, nil
 }
 
 type fileAndWriter struct {
@@ -48,6 +47,11 @@ type fileAndWriter struct {
 }
 
 func (fw *fileAndWriter) Close() error {
+	fw.w.Flush()
+	if err := fw.w.Error(); err != nil {
+		fw.f.Close()
+		return err
+	}
 	return fw.f.Close()
 }
 
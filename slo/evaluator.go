@@ -0,0 +1,266 @@
+package slo
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spenczar/tdigest"
+)
+
+// Observation is a snapshot of the load test state, taken at each evaluation.
+type Observation struct {
+	Time time.Time
+	// Cumulative number of requests sent and errors.
+	Requests, Errors int64
+	// Instantaneous CPU usage, in percent. Ignored unless HasCPU is true.
+	CPU    float64
+	HasCPU bool
+}
+
+// bucket keeps what was observed between two evaluations.
+type bucket struct {
+	ts               time.Time
+	latencies        []float64
+	requests, errors int64
+	cpu              float64
+	hasCPU           bool
+}
+
+// Evaluator evaluates thresholds over a sliding window (or the whole run, if the window is zero) and
+// detects sustained breaches. Latencies can be recorded concurrently.
+type Evaluator struct {
+	thresholds []Threshold
+	window     time.Duration
+	sustain    time.Duration
+
+	mu      sync.Mutex
+	pending []float64
+
+	start           time.Time
+	last            Observation
+	buckets         []bucket
+	total           tdigest.TDigest
+	totalLatencies  int64
+	totalCPU        float64
+	totalCPUSamples int64
+	breachedSince   []time.Time
+	breachedFor     []time.Duration
+	abortedBy       *Threshold
+	abortedAt       time.Time
+}
+
+// NewEvaluator returns an evaluator of the thresholds. A threshold breached for sustain (or longer) aborts
+// the load test.
+func NewEvaluator(thresholds []Threshold, window, sustain time.Duration, start time.Time) *Evaluator {
+	return &Evaluator{
+		thresholds:    thresholds,
+		window:        window,
+		sustain:       sustain,
+		start:         start,
+		last:          Observation{Time: start},
+		total:         tdigest.New(),
+		breachedSince: make([]time.Time, len(thresholds)),
+		breachedFor:   make([]time.Duration, len(thresholds)),
+	}
+}
+
+// RecordLatency records the latency of a successful request, in milliseconds.
+func (e *Evaluator) RecordLatency(millis float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.pending = append(e.pending, millis)
+}
+
+// Evaluate evaluates all thresholds over the window ending at o.Time. It returns the threshold which has
+// been breached for the sustain period, nil if none.
+func (e *Evaluator) Evaluate(o Observation) *Threshold {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.observe(o)
+
+	values := e.totalValues()
+	if e.window > 0 {
+		values = windowValues(e.buckets)
+	}
+	for i, t := range e.thresholds {
+		v, ok := values[t.Metric]
+		if !ok || t.Met(v) {
+			e.breachedSince[i] = time.Time{}
+			continue
+		}
+		if e.breachedSince[i].IsZero() {
+			e.breachedSince[i] = o.Time
+		}
+		breachedFor := o.Time.Sub(e.breachedSince[i])
+		if breachedFor > e.breachedFor[i] {
+			e.breachedFor[i] = breachedFor
+		}
+		if breachedFor >= e.sustain && e.abortedBy == nil {
+			e.abortedBy = &e.thresholds[i]
+			e.abortedAt = o.Time
+			return e.abortedBy
+		}
+	}
+	return nil
+}
+
+// observe folds what was recorded since the last observation into the window and the whole run totals.
+func (e *Evaluator) observe(o Observation) {
+	b := bucket{
+		ts:        o.Time,
+		latencies: e.pending,
+		requests:  o.Requests - e.last.Requests,
+		errors:    o.Errors - e.last.Errors,
+		cpu:       o.CPU,
+		hasCPU:    o.HasCPU,
+	}
+	e.pending = nil
+	e.last = o
+	for _, l := range b.latencies {
+		e.total.Add(l, 1)
+	}
+	e.totalLatencies += int64(len(b.latencies))
+	if b.hasCPU {
+		e.totalCPU += b.cpu
+		e.totalCPUSamples++
+	}
+
+	if e.window > 0 {
+		e.buckets = append(e.buckets, b)
+		for len(e.buckets) > 0 && o.Time.Sub(e.buckets[0].ts) >= e.window {
+			e.buckets = e.buckets[1:]
+		}
+	}
+}
+
+// windowValues returns the metric values observed in the buckets. Metrics without data are missing.
+func windowValues(buckets []bucket) map[string]float64 {
+	values := make(map[string]float64)
+	var latencies []float64
+	var requests, errors, cpuSamples int64
+	var cpu float64
+	for _, b := range buckets {
+		latencies = append(latencies, b.latencies...)
+		requests += b.requests
+		errors += b.errors
+		if b.hasCPU {
+			cpu += b.cpu
+			cpuSamples++
+		}
+	}
+	if len(latencies) > 0 {
+		sort.Float64s(latencies)
+		for m, q := range quantiles {
+			i := int(math.Ceil(q*float64(len(latencies)))) - 1
+			if i < 0 {
+				i = 0
+			}
+			values[m] = latencies[i]
+		}
+	}
+	if requests > 0 {
+		values[ErrorRate] = float64(errors) / float64(requests)
+	}
+	if cpuSamples > 0 {
+		values[CPU] = cpu / float64(cpuSamples)
+	}
+	return values
+}
+
+func (e *Evaluator) totalValues() map[string]float64 {
+	values := make(map[string]float64)
+	if e.totalLatencies > 0 {
+		for m, q := range quantiles {
+			values[m] = e.total.Quantile(q)
+		}
+	}
+	if e.last.Requests > 0 {
+		values[ErrorRate] = float64(e.last.Errors) / float64(e.last.Requests)
+	}
+	if e.totalCPUSamples > 0 {
+		values[CPU] = e.totalCPU / float64(e.totalCPUSamples)
+	}
+	return values
+}
+
+// ThresholdVerdict is the outcome of one threshold.
+type ThresholdVerdict struct {
+	Expr string `json:"threshold"`
+	// Value observed over the whole run. Nil if there is no data (i.e. cpu without monitoring).
+	Value *float64 `json:"value"`
+	Pass  bool     `json:"pass"`
+	// Longest period the threshold was continuously breached while running.
+	BreachedFor string `json:"breached_for"`
+}
+
+// Verdict is the outcome of the load test.
+type Verdict struct {
+	Pass      bool   `json:"pass"`
+	Aborted   bool   `json:"aborted"`
+	AbortedBy string `json:"aborted_by,omitempty"`
+	// The load test did not run to the end (i.e. it was interrupted or failed), thresholds only cover what ran.
+	Incomplete bool               `json:"incomplete"`
+	StoppedBy  string             `json:"stopped_by,omitempty"`
+	Duration   string             `json:"duration"`
+	Requests   int64              `json:"requests"`
+	Errors     int64              `json:"errors"`
+	Thresholds []ThresholdVerdict `json:"thresholds"`
+}
+
+// Verdict returns the outcome of the load test. Thresholds are evaluated over the whole run and the run
+// fails if any of them is not met or the run was aborted. Thresholds without data pass.
+func (e *Evaluator) Verdict(o Observation) *Verdict {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.observe(o)
+	end := o.Time
+	if e.abortedBy != nil {
+		end = e.abortedAt
+	}
+	v := &Verdict{
+		Pass:     e.abortedBy == nil,
+		Aborted:  e.abortedBy != nil,
+		Duration: end.Sub(e.start).String(),
+		Requests: o.Requests,
+		Errors:   o.Errors,
+	}
+	if e.abortedBy != nil {
+		v.AbortedBy = e.abortedBy.Expr
+	}
+	values := e.totalValues()
+	for i, t := range e.thresholds {
+		tv := ThresholdVerdict{Expr: t.Expr, Pass: true, BreachedFor: e.breachedFor[i].String()}
+		if value, ok := values[t.Metric]; ok {
+			tv.Value = &value
+			tv.Pass = t.Met(value) && (e.abortedBy == nil || e.abortedBy.Expr != t.Expr)
+		}
+		if !tv.Pass {
+			v.Pass = false
+		}
+		v.Thresholds = append(v.Thresholds, tv)
+	}
+	return v
+}
+
+// Error is returned when the load test does not meet its thresholds.
+type Error struct {
+	Verdict *Verdict
+}
+
+func (e *Error) Error() string {
+	if e.Verdict.Aborted {
+		return fmt.Sprintf("load test aborted, threshold breached: %s", e.Verdict.AbortedBy)
+	}
+	return "load test failed, thresholds not met"
+}
+
+// ExitCode returns the process exit code: 2 if thresholds were not met and 3 if the load test was aborted.
+func (e *Error) ExitCode() int {
+	if e.Verdict.Aborted {
+		return 3
+	}
+	return 2
+}
@@ -0,0 +1,82 @@
+package slo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func mustParse(exprs ...string) []Threshold {
+	var ts []Threshold
+	for _, e := range exprs {
+		t, err := Parse(e)
+		if err != nil {
+			panic(err)
+		}
+		ts = append(ts, t)
+	}
+	return ts
+}
+
+func TestEvaluator_Pass(t *testing.T) {
+	is := is.New(t)
+	start := time.Unix(0, 0)
+	e := NewEvaluator(mustParse("p99<100ms", "error_rate<10%", "cpu<90"), 0, 0, start)
+	for i := 1; i <= 10; i++ {
+		e.RecordLatency(float64(i))
+		is.Equal(e.Evaluate(Observation{Time: start.Add(time.Duration(i) * time.Second), Requests: int64(i)}), (*Threshold)(nil))
+	}
+	v := e.Verdict(Observation{Time: start.Add(10 * time.Second), Requests: 10})
+	is.True(v.Pass)
+	is.True(!v.Aborted)
+	is.Equal(v.Duration, "10s")
+	is.Equal(len(v.Thresholds), 3)
+	is.True(*v.Thresholds[0].Value >= 9)
+	is.Equal(*v.Thresholds[1].Value, 0.0)
+	// No CPU data.
+	is.Equal(v.Thresholds[2].Value, (*float64)(nil))
+	is.True(v.Thresholds[2].Pass)
+}
+
+func TestEvaluator_SustainedBreach(t *testing.T) {
+	is := is.New(t)
+	start := time.Unix(0, 0)
+	e := NewEvaluator(mustParse("p99<100ms", "error_rate<1%"), 2*time.Second, 3*time.Second, start)
+	at := func(s int) time.Time { return start.Add(time.Duration(s) * time.Second) }
+
+	// A single slow second is not enough.
+	e.RecordLatency(500)
+	is.Equal(e.Evaluate(Observation{Time: at(1), Requests: 1}), (*Threshold)(nil))
+	// Back to normal once the slow second leaves the window.
+	for s := 2; s <= 3; s++ {
+		e.RecordLatency(10)
+		is.Equal(e.Evaluate(Observation{Time: at(s), Requests: int64(s)}), (*Threshold)(nil))
+	}
+	// Errors from now on, aborts after 3s.
+	is.Equal(e.Evaluate(Observation{Time: at(4), Requests: 5, Errors: 1}), (*Threshold)(nil))
+	is.Equal(e.Evaluate(Observation{Time: at(5), Requests: 7, Errors: 2}), (*Threshold)(nil))
+	is.Equal(e.Evaluate(Observation{Time: at(6), Requests: 9, Errors: 3}), (*Threshold)(nil))
+	breached := e.Evaluate(Observation{Time: at(7), Requests: 11, Errors: 4})
+	is.True(breached != nil)
+	is.Equal(breached.Expr, "error_rate<1%")
+
+	v := e.Verdict(Observation{Time: at(8), Requests: 11, Errors: 4})
+	is.True(!v.Pass)
+	is.True(v.Aborted)
+	is.Equal(v.AbortedBy, "error_rate<1%")
+	is.Equal(v.Duration, "7s")
+	is.Equal(v.Thresholds[1].BreachedFor, "3s")
+	is.True(!v.Thresholds[1].Pass)
+	is.Equal((&Error{v}).ExitCode(), 3)
+}
+
+func TestEvaluator_FailsOverWholeRun(t *testing.T) {
+	is := is.New(t)
+	start := time.Unix(0, 0)
+	e := NewEvaluator(mustParse("error_rate<1%"), time.Second, time.Minute, start)
+	v := e.Verdict(Observation{Time: start.Add(time.Second), Requests: 10, Errors: 5})
+	is.True(!v.Pass)
+	is.True(!v.Aborted)
+	is.Equal((&Error{v}).ExitCode(), 2)
+}
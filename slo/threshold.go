@@ -0,0 +1,84 @@
+package slo
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supported metrics.
+const (
+	P50       = "p50"
+	P90       = "p90"
+	P99       = "p99"
+	P999      = "p999"
+	ErrorRate = "error_rate"
+	CPU       = "cpu"
+)
+
+var quantiles = map[string]float64{P50: 0.5, P90: 0.9, P99: 0.99, P999: 0.999}
+
+// Threshold is a declarative service level objective, i.e. p99<200ms.
+type Threshold struct {
+	// Expression as passed in to Parse.
+	Expr   string
+	Metric string
+	// One of <, <=, > or >=.
+	Op string
+	// Latencies are in milliseconds, error rates are ratios and CPU usage is a percentage.
+	Limit float64
+}
+
+var thresholdRE = regexp.MustCompile(`^\s*([a-z0-9_]+)\s*(<=|>=|<|>)\s*(\S+)\s*$`)
+
+// Parse parses threshold expressions like p99<200ms, error_rate<1% or cpu<90. Latencies without unit
+// are in milliseconds and error rates without % are ratios.
+func Parse(expr string) (Threshold, error) {
+	m := thresholdRE.FindStringSubmatch(expr)
+	if m == nil {
+		return Threshold{}, fmt.Errorf("invalid threshold:%q", expr)
+	}
+	t := Threshold{Expr: expr, Metric: m[1], Op: m[2]}
+	value := m[3]
+	var err error
+	switch {
+	case quantiles[t.Metric] > 0:
+		var d time.Duration
+		if d, err = time.ParseDuration(value); err == nil {
+			t.Limit = float64(d) / float64(time.Millisecond)
+		} else {
+			t.Limit, err = strconv.ParseFloat(value, 64)
+		}
+	case t.Metric == ErrorRate:
+		if strings.HasSuffix(value, "%") {
+			t.Limit, err = strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+			t.Limit /= 100
+		} else {
+			t.Limit, err = strconv.ParseFloat(value, 64)
+		}
+	case t.Metric == CPU:
+		t.Limit, err = strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	default:
+		return Threshold{}, fmt.Errorf("invalid threshold metric:%q", t.Metric)
+	}
+	if err != nil {
+		return Threshold{}, fmt.Errorf("invalid threshold value:%q", expr)
+	}
+	return t, nil
+}
+
+// Met returns whether the observed value meets the threshold.
+func (t Threshold) Met(v float64) bool {
+	switch t.Op {
+	case "<":
+		return v < t.Limit
+	case "<=":
+		return v <= t.Limit
+	case ">":
+		return v > t.Limit
+	default:
+		return v >= t.Limit
+	}
+}
@@ -0,0 +1,38 @@
+package slo
+
+import (
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestParse(t *testing.T) {
+	is := is.New(t)
+	for expr, want := range map[string]Threshold{
+		"p99<200ms":        {Metric: P99, Op: "<", Limit: 200},
+		"p50 <= 1.5s":      {Metric: P50, Op: "<=", Limit: 1500},
+		"p999<30":          {Metric: P999, Op: "<", Limit: 30},
+		"error_rate<1%":    {Metric: ErrorRate, Op: "<", Limit: 0.01},
+		"error_rate<=0.05": {Metric: ErrorRate, Op: "<=", Limit: 0.05},
+		"cpu<90":           {Metric: CPU, Op: "<", Limit: 90},
+	} {
+		got, err := Parse(expr)
+		is.NoErr(err)
+		want.Expr = expr
+		is.Equal(got, want)
+	}
+	for _, expr := range []string{"p99", "p98<10ms", "p99<foo", "cpu=90", "error_rate<x%"} {
+		_, err := Parse(expr)
+		is.True(err != nil)
+	}
+}
+
+func TestThreshold_Met(t *testing.T) {
+	is := is.New(t)
+	lt, _ := Parse("p99<200")
+	is.True(lt.Met(199))
+	is.True(!lt.Met(200))
+	ge, _ := Parse("cpu>=10")
+	is.True(ge.Met(10))
+	is.True(!ge.Met(9))
+}